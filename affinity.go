@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"regexp"
+)
+
+// providerLimit caps the workers talking to the mx hosts of a provider at the same time, across
+// all the requests, so a batch full of gmail addresses never has every worker hitting gmail at once
+type providerLimit struct {
+	// Provider is matched against the mx host
+	Provider string `json:"provider"`
+	Workers  int    `json:"workers"`
+
+	providerRegex *regexp.Regexp
+	slots         chan struct{}
+}
+
+// compileProviderLimit compiles the regex of the limit and makes its slots
+func compileProviderLimit(limit *providerLimit) (err error) {
+	if limit.providerRegex, err = regexp.Compile(limit.Provider); err != nil {
+		return err
+	}
+	if limit.Workers > 0 {
+		limit.slots = make(chan struct{}, limit.Workers)
+	}
+	return nil
+}
+
+// acquireProvider waits for a slot of the first limit matching the mx host and returns
+// the func giving it back, it fails only when the context is done first
+func acquireProvider(ctx context.Context, mxHost string) (func(), error) {
+	for _, limit := range config.WorkProviderLimits {
+		if !limit.providerRegex.MatchString(mxHost) {
+			continue
+		}
+		if limit.slots == nil {
+			break
+		}
+		select {
+		case limit.slots <- struct{}{}:
+			slots := limit.slots
+			return func() { <-slots }, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() {}, nil
+}
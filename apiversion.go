@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// latestAPIVersion is the response schema used when the client does not ask for one.
+// v1 only has the flat emails map, v2 adds the results map with the details of each email
+const latestAPIVersion = 2
+
+// apiVersionWriter carries the response schema the client asked for down to sendHTTPJSONResponse
+type apiVersionWriter struct {
+	http.ResponseWriter
+	version int
+}
+
+// requestedAPIVersion reads the version from the apiVersion query param, or else from
+// an Accept header like application/vnd.evs.v1+json, the latest one when none is asked for
+func requestedAPIVersion(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("apiVersion")
+	if len(v) == 0 {
+		for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+			mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accept))
+			if strings.HasPrefix(mediaType, "application/vnd.evs.v") && strings.HasSuffix(mediaType, "+json") {
+				v = strings.TrimSuffix(strings.TrimPrefix(mediaType, "application/vnd.evs.v"), "+json")
+				break
+			}
+		}
+	}
+	if len(v) == 0 {
+		return latestAPIVersion, nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+	if err != nil || version < 1 || version > latestAPIVersion {
+		return 0, fmt.Errorf("unsupported api version: %q", v)
+	}
+	return version, nil
+}
+
+// apiVersionOf returns the response schema to use for the response being written
+func apiVersionOf(w http.ResponseWriter) int {
+	if vw, ok := w.(*apiVersionWriter); ok {
+		return vw.version
+	}
+	return latestAPIVersion
+}
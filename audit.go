@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry is the line appended to the audit log for each validated email
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Email      string    `json:"email"`
+	Verdict    string    `json:"verdict"`
+	ReasonCode string    `json:"reasonCode,omitempty"`
+	ClientIP   string    `json:"clientIp,omitempty"`
+}
+
+// auditLog appends the verdicts to a file, one json line each. the file is rotated once it grows
+// over maxSize or gets older than maxAge, the rotated ones get the time of the rotation appended
+// to their name and are never touched again
+type auditLog struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newAuditLog(path string, maxSize int64, maxAge time.Duration) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f = f
+	a.size = fi.Size()
+	a.openedAt = time.Now()
+	return nil
+}
+
+func (a *auditLog) rotate() error {
+	err := a.f.Close()
+	a.f = nil
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(a.path, a.path+"."+time.Now().Format("20060102-150405.000")); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// record appends the verdict of the email, along with the address of the client who asked for it
+func (a *auditLog) record(ctx context.Context, email string, res *emailResult) {
+	if config.AuditHashEmails {
+		email = hashEmail(email)
+	}
+	b, err := json.Marshal(&auditEntry{
+		Time:       time.Now(),
+		Email:      email,
+		Verdict:    res.Message,
+		ReasonCode: res.ReasonCode,
+		ClientIP:   optionsFrom(ctx).clientIP,
+	})
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+	if a.f == nil {
+		// a failed rotation left no file open, try again
+		if err = a.open(); err != nil {
+			fmt.Println("Audit log open error:", err)
+			return
+		}
+	}
+	if a.size > 0 && ((a.maxSize > 0 && a.size+int64(len(b)) > a.maxSize) || (a.maxAge > 0 && time.Since(a.openedAt) > a.maxAge)) {
+		if err = a.rotate(); err != nil {
+			fmt.Println("Audit log rotate error:", err)
+			if a.f == nil {
+				return
+			}
+		}
+	}
+	n, err := a.f.Write(b)
+	a.size += int64(n)
+	if err != nil {
+		fmt.Println("Audit log write error:", err)
+	}
+}
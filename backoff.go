@@ -0,0 +1,46 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// domainsBackoff keeps the domains whose mx hosts told us to slow down, like with a 421 too many
+// connections. their emails are deferred until the cooldown is over instead of adding to the pile
+type domainsBackoff struct {
+	sync.Mutex
+	cooldown time.Duration
+	until    map[string]time.Time
+}
+
+var metricSMTPDeferred = expvar.NewInt("smtp.deferred")
+
+func newDomainsBackoff(cooldown time.Duration) *domainsBackoff {
+	return &domainsBackoff{cooldown: cooldown, until: make(map[string]time.Time)}
+}
+
+// hold starts, or extends, the cooldown of the domain
+func (b *domainsBackoff) hold(domainName string) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.until[domainName] = time.Now().Add(b.cooldown)
+}
+
+// held reports whether the domain is still cooling down
+func (b *domainsBackoff) held(domainName string) bool {
+	if b == nil {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	until, ok := b.until[domainName]
+	if ok && time.Now().After(until) {
+		delete(b.until, domainName)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catchAllDomains detects and caches whether domains accept any email address.
+// detection probes have their own concurrency limit so they never starve the primary validations
+type catchAllDomains struct {
+	sync.Mutex
+	gcFrequency time.Duration
+	data        map[string]bool
+	slots       chan struct{}
+	// probes are the detections in progress, the emails of the same domain wait for them
+	probes map[string]*catchAllProbe
+}
+
+type catchAllProbe struct {
+	done   chan struct{}
+	result *bool
+}
+
+// join returns the detection in progress for the domain and whether the caller has to do it
+func (d *catchAllDomains) join(domainName string) (*catchAllProbe, bool) {
+	d.Lock()
+	defer d.Unlock()
+	if p, ok := d.probes[domainName]; ok {
+		return p, false
+	}
+	p := &catchAllProbe{done: make(chan struct{})}
+	d.probes[domainName] = p
+	return p, true
+}
+
+// finish hands the result of the detection to the ones waiting for it
+func (d *catchAllDomains) finish(domainName string, p *catchAllProbe) {
+	d.Lock()
+	delete(d.probes, domainName)
+	d.Unlock()
+	close(p.done)
+}
+
+func (d *catchAllDomains) get(k string) (bool, bool) {
+	d.Lock()
+	defer d.Unlock()
+	v, ok := d.data[k]
+	return v, ok
+}
+
+func (d *catchAllDomains) add(k string, v bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.data[k] = v
+}
+
+func (d *catchAllDomains) gcHandler() {
+	ticker := time.NewTicker(d.gcFrequency)
+	for _ = range ticker.C {
+		d.Lock()
+		d.data = make(map[string]bool)
+		d.Unlock()
+	}
+}
+
+// acquire takes a probe slot, in lazy mode only if one is available right away,
+// otherwise as long as the context is not done
+func (d *catchAllDomains) acquire(ctx context.Context) bool {
+	if config.CatchAllLazy {
+		select {
+		case d.slots <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case d.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (d *catchAllDomains) release() {
+	<-d.slots
+}
+
+// detect tells whether the domain accepts any address for the email, from the provider
+// rules or by asking the mx host for a random one. it returns nil when the detection could not be done.
+// the emails of a domain being probed wait for that probe instead of making their own
+func (d *catchAllDomains) detect(ctx context.Context, email, host string) *bool {
+	if scope := optionsFrom(ctx).scope; scope != nil && d != scope.catchAll {
+		return scope.catchAll.detect(ctx, email, host)
+	}
+	if v, ok := catchAllByRule(email, host); ok {
+		return &v
+	}
+
+	domainName := emailDomain(email)
+	if v, ok := d.get(domainName); ok {
+		return &v
+	}
+
+	p, first := d.join(domainName)
+	if !first {
+		select {
+		case <-p.done:
+			return p.result
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	defer d.finish(domainName, p)
+	p.result = d.probe(ctx, domainName, host)
+	return p.result
+}
+
+// probe asks the mx host for a random address of the domain, only a definitive answer is cached
+func (d *catchAllDomains) probe(ctx context.Context, domainName, host string) *bool {
+	if !d.acquire(ctx) {
+		return nil
+	}
+	defer d.release()
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil
+	}
+	random := fmt.Sprintf("evs-%s@%s", hex.EncodeToString(b), domainName)
+
+	ov := domainOverrideFor(domainName)
+	c, err := smtpConnect(ctx, host, ov, connAuxiliary)
+	if err != nil {
+		return nil
+	}
+	defer c.close()
+
+	if err = smtpGreet(c, domainName, host, ov); err != nil {
+		return nil
+	}
+
+	err = c.Rcpt(random)
+	if ctx.Err() != nil {
+		return nil
+	}
+	isCatchAll, ok := catchAllAnswer(err)
+	if !ok {
+		return nil
+	}
+	d.add(domainName, isCatchAll)
+	if config.Verbose {
+		fmt.Println("Catch-all detection for", domainName, "says:", isCatchAll)
+	}
+	return &isCatchAll
+}
+
+// catchAllAnswer reads the answer to the RCPT of the random address, accepted means catch-all
+// and a permanent rejection means not. a greylisting 4xx, a timeout or a dropped connection
+// tells nothing either way, so ok is false then
+func catchAllAnswer(err error) (bool, bool) {
+	if err == nil {
+		return true, true
+	}
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 && tpErr.Code < 600 {
+		return false, true
+	}
+	return false, false
+}
+
+// catchAllRule tells upfront whether a provider accepts the emails whose local part
+// matches the pattern, for providers known to behave this way, no probe is needed then
+type catchAllRule struct {
+	// Provider is matched against the mx host
+	Provider string `json:"provider"`
+	// Local is matched against the local part of the email
+	Local    string `json:"local"`
+	CatchAll bool   `json:"catchAll"`
+
+	providerRegex *regexp.Regexp
+	localRegex    *regexp.Regexp
+}
+
+// defaultCatchAllRules are used unless catchall.rules is set in the configuration file
+var defaultCatchAllRules = []catchAllRule{
+	// icloud accepts any subaddress of a mailbox, whatever comes after the +
+	{Provider: `(?i)mail\.icloud\.com`, Local: `\+`, CatchAll: true},
+}
+
+// compileCatchAllRule compiles the regexes of the rule
+func compileCatchAllRule(rule *catchAllRule) (err error) {
+	if rule.providerRegex, err = regexp.Compile(rule.Provider); err != nil {
+		return err
+	}
+	rule.localRegex, err = regexp.Compile(rule.Local)
+	return err
+}
+
+// catchAllByRule returns the verdict of the first rule matching the email and the mx host
+func catchAllByRule(email, host string) (bool, bool) {
+	local := email[:strings.LastIndex(email, "@")]
+	for _, rule := range config.CatchAllRules {
+		if rule.providerRegex.MatchString(host) && rule.localRegex.MatchString(local) {
+			return rule.CatchAll, true
+		}
+	}
+	return false, false
+}
+
+func newCatchAllDomains() *catchAllDomains {
+	concurrency := config.CatchAllConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	d := &catchAllDomains{
+		gcFrequency: time.Second * time.Duration(config.CatchAllGCFrequency),
+		data:        make(map[string]bool),
+		slots:       make(chan struct{}, concurrency),
+		probes:      make(map[string]*catchAllProbe),
+	}
+	if config.CatchAllGCFrequency > 0 {
+		go d.gcHandler()
+	}
+	return d
+}
+
+// catchAllTreatments are the deliverabilities and the verdicts of the accepted emails of the catch-all
+// domains, per catchall.treatas. the catchAll flag of the result is kept whatever the mapping
+var catchAllTreatments = map[string]struct{ deliverability, verdict string }{
+	"valid":    {"deliverable", "OK"},
+	"catchall": {"catchall", "catch-all (the domain accepts any address)"},
+	"risky":    {"risky", "risky (the domain accepts any address)"},
+	"invalid":  {"undeliverable", "invalid (the domain accepts any address)"},
+}
+
+// treatCatchAll maps the verdict and the deliverability of an accepted email on a catch-all domain
+func treatCatchAll(res *emailResult, verdict string) string {
+	if res.CatchAll == nil || !*res.CatchAll || res.Deliverability != "deliverable" {
+		return verdict
+	}
+	treatment := catchAllTreatments[config.CatchAllTreatAs]
+	res.Deliverability = treatment.deliverability
+	res.Valid = res.Deliverability == "deliverable"
+	if res.Valid {
+		return verdict
+	}
+	return treatment.verdict
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCatchAllAnswer(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		catchAll   bool
+		definitive bool
+	}{
+		{"accepted", nil, true, true},
+		{"unknown user", &textproto.Error{Code: 550, Msg: "5.1.1 no such user"}, false, true},
+		{"rejected", &textproto.Error{Code: 554, Msg: "5.7.1 rejected"}, false, true},
+		{"greylisted", &textproto.Error{Code: 451, Msg: "4.7.1 try again later"}, false, false},
+		{"busy", &textproto.Error{Code: 421, Msg: "4.3.2 closing"}, false, false},
+		{"dropped", io.EOF, false, false},
+		{"timeout", context.DeadlineExceeded, false, false},
+		{"wrapped", errors.Join(errors.New("rcpt"), &textproto.Error{Code: 550}), false, true},
+	}
+	for _, tt := range tests {
+		catchAll, ok := catchAllAnswer(tt.err)
+		if catchAll != tt.catchAll || ok != tt.definitive {
+			t.Errorf("%s: catchAllAnswer = %v, %v, want %v, %v", tt.name, catchAll, ok, tt.catchAll, tt.definitive)
+		}
+	}
+}
+
+// only the definitive answers are cached, and the emails of a domain share a single probe
+func TestCatchAllDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  string
+		want   *bool
+		cached bool
+	}{
+		{"catch-all", "250 2.1.5 ok", boolPtr(true), true},
+		{"not catch-all", "550 5.1.1 no such user", boolPtr(false), true},
+		{"greylisted", "451 4.7.1 greylisted", nil, false},
+		{"dropped", "", nil, false},
+	}
+	defer func(ov map[string]*domainOverride) { config.DomainsOverrides = ov }(config.DomainsOverrides)
+	for _, tt := range tests {
+		reply := tt.reply
+		mx := startFakeMX(t, &fakeMX{ehlo: []string{}, rcpt: func(string) string {
+			time.Sleep(50 * time.Millisecond)
+			return reply
+		}})
+		config.DomainsOverrides = map[string]*domainOverride{"example.com": {Port: mx.port(), Timeout: 5}}
+		d := newCatchAllDomains()
+
+		var wg sync.WaitGroup
+		results := make([]*bool, 5)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = d.detect(context.Background(), "user@example.com", "127.0.0.1")
+			}(i)
+		}
+		wg.Wait()
+
+		for _, got := range results {
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("%s: detect = %v, want %v", tt.name, fmtBool(got), fmtBool(tt.want))
+			}
+		}
+		if n := atomic.LoadInt32(&mx.conns); n != 1 {
+			t.Errorf("%s: %d probes, want 1", tt.name, n)
+		}
+		if _, ok := d.get("example.com"); ok != tt.cached {
+			t.Errorf("%s: cached %v, want %v", tt.name, ok, tt.cached)
+		}
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func fmtBool(b *bool) string {
+	if b == nil {
+		return "nil"
+	}
+	if *b {
+		return "true"
+	}
+	return "false"
+}
+
+func TestTreatCatchAll(t *testing.T) {
+	tests := []struct {
+		treatAs        string
+		catchAll       *bool
+		verdict        string
+		deliverability string
+		valid          bool
+	}{
+		{"valid", boolPtr(true), "OK", "deliverable", true},
+		{"catchall", boolPtr(true), "catch-all (the domain accepts any address)", "catchall", false},
+		{"risky", boolPtr(true), "risky (the domain accepts any address)", "risky", false},
+		{"invalid", boolPtr(true), "invalid (the domain accepts any address)", "undeliverable", false},
+		{"invalid", boolPtr(false), "OK", "deliverable", true},
+		{"invalid", nil, "OK", "deliverable", true},
+	}
+	defer func(treatAs string) { config.CatchAllTreatAs = treatAs }(config.CatchAllTreatAs)
+	for _, tt := range tests {
+		config.CatchAllTreatAs = tt.treatAs
+		res := &emailResult{CatchAll: tt.catchAll}
+		setReason(res, "a@example.com", "OK", "OK")
+		verdict := treatCatchAll(res, "OK")
+		if verdict != tt.verdict || res.Deliverability != tt.deliverability || res.Valid != tt.valid {
+			t.Errorf("treatCatchAll as %s, catch-all %s = %q %s valid %v, want %q %s valid %v", tt.treatAs, fmtBool(tt.catchAll),
+				verdict, res.Deliverability, res.Valid, tt.verdict, tt.deliverability, tt.valid)
+		}
+	}
+}
+
+// the default rules only name the providers known to accept a pattern, a yahoo address is probed like any other
+func TestCatchAllByRule(t *testing.T) {
+	tests := []struct {
+		email    string
+		host     string
+		catchAll bool
+		known    bool
+	}{
+		{"someone@yahoo.com", "mta5.am0.yahoodns.net", false, false},
+		{"someone@aol.com", "mx-aol.mail.gm0.yahoodns.net", false, false},
+		{"someone+news@icloud.com", "mx01.mail.icloud.com", true, true},
+		{"someone@icloud.com", "mx01.mail.icloud.com", false, false},
+	}
+	for _, tt := range tests {
+		catchAll, known := catchAllByRule(tt.email, tt.host)
+		if catchAll != tt.catchAll || known != tt.known {
+			t.Errorf("catchAllByRule(%s, %s) = %v %v, want %v %v", tt.email, tt.host, catchAll, known, tt.catchAll, tt.known)
+		}
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	tests := []struct {
+		rules []catchAllRule
+		err   bool
+	}{
+		{[]catchAllRule{{Provider: `example\.com`, Local: `\+`}}, false},
+		{[]catchAllRule{{Provider: `example\.com`, Local: `(`}}, true},
+		{[]catchAllRule{{Provider: `[`, Local: `.`}}, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		compiled, err := compileRules(tt.rules, compileCatchAllRule)
+		if (err != nil) != tt.err {
+			t.Errorf("compileRules(%v) error = %v, want error %v", tt.rules, err, tt.err)
+			continue
+		}
+		for i := range compiled {
+			if compiled[i].providerRegex == nil || compiled[i].localRegex == nil || tt.rules[i].localRegex != nil {
+				t.Errorf("compileRules(%v) compiled %+v, the rules given must stay as they are", tt.rules, compiled[i])
+			}
+		}
+	}
+}
+
+func TestCatchAllAcquire(t *testing.T) {
+	defer func(lazy bool) { config.CatchAllLazy = lazy }(config.CatchAllLazy)
+	tests := []struct {
+		name      string
+		lazy      bool
+		full      bool
+		cancelled bool
+		want      bool
+	}{
+		{"free slot", false, false, false, true},
+		{"full, cancelled", false, true, true, false},
+		{"full, deadline", false, true, false, false},
+		{"lazy, free slot", true, false, false, true},
+		{"lazy, full", true, true, false, false},
+	}
+	for _, tt := range tests {
+		config.CatchAllLazy = tt.lazy
+		d := &catchAllDomains{slots: make(chan struct{}, 1)}
+		if tt.full {
+			d.slots <- struct{}{}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if tt.cancelled {
+			cancel()
+		}
+		if got := d.acquire(ctx); got != tt.want {
+			t.Errorf("%s: acquire = %v, want %v", tt.name, got, tt.want)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+)
+
+// previousVerdict is the cached verdict of an email from an earlier run
+type previousVerdict struct {
+	message        string
+	code           string
+	deliverability string
+	at             time.Time
+}
+
+// readPrevious returns the cached verdicts of the emails, they are left in the caches
+func readPrevious(emails []string) map[string]previousVerdict {
+	previous := make(map[string]previousVerdict)
+	for _, e := range emails {
+		for _, c := range []*emailsCache{eCache, eJunkCache} {
+			if c == nil {
+				continue
+			}
+			if item, ok := c.get(e); ok {
+				res := &emailResult{}
+				message := cachedVerdict(res, e, item)
+				previous[e] = previousVerdict{message: message, code: res.ReasonCode, deliverability: res.Deliverability, at: item.cachedAt}
+				break
+			}
+		}
+	}
+	return previous
+}
+
+// keepChanged drops the results whose verdict is the same as the previous one and returns how many are left.
+// the verdicts are told apart by their reason code and deliverability, the wording of the smtp
+// responses changes from one attempt to the next. the emails without a previous verdict only set
+// the baseline for the next run, they are dropped as well
+func keepChanged(o *outgoingEmails, previous map[string]previousVerdict) int {
+	o.Lock()
+	defer o.Unlock()
+	for e, res := range o.Results {
+		p, ok := previous[e]
+		if !ok || p.code == res.ReasonCode && p.deliverability == res.Deliverability {
+			delete(o.Results, e)
+			delete(o.Emails, e)
+			continue
+		}
+		at := p.at
+		res.PreviousMessage = p.message
+		res.PreviousAt = &at
+	}
+	return len(o.Results)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepChanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous *previousVerdict
+		code     string
+		deliv    string
+		kept     bool
+	}{
+		{"first seen", nil, "OK", "deliverable", false},
+		{"same", &previousVerdict{code: "OK", deliverability: "deliverable"}, "OK", "deliverable", false},
+		{"reworded", &previousVerdict{message: "550 5.1.1 no such user", code: "MAILBOX_NOT_FOUND", deliverability: "undeliverable"}, "MAILBOX_NOT_FOUND", "undeliverable", false},
+		{"now invalid", &previousVerdict{code: "OK", deliverability: "deliverable"}, "MAILBOX_NOT_FOUND", "undeliverable", true},
+		{"now catch-all", &previousVerdict{code: "OK", deliverability: "deliverable"}, "OK", "catchall", true},
+	}
+	for _, tt := range tests {
+		o := newOutgoingEmails(1)
+		o.Add("a@example.com", &emailResult{Message: "550 5.1.1 user unknown here", ReasonCode: tt.code, Deliverability: tt.deliv})
+		previous := map[string]previousVerdict{}
+		if tt.previous != nil {
+			previous["a@example.com"] = *tt.previous
+		}
+		if got := keepChanged(o, previous); (got == 1) != tt.kept {
+			t.Errorf("%s: kept %d, want %v", tt.name, got, tt.kept)
+		}
+	}
+}
+
+// reading the baseline leaves it in the cache, only a fresh verdict replaces it
+func TestReadPrevious(t *testing.T) {
+	defer func(c *emailsCache) { eCache = c }(eCache)
+	eCache = newEmailsCache(10)
+	eCache.add("a@example.com", "domain does not accept mail", "NULL_MX", time.Hour)
+
+	previous := readPrevious([]string{"a@example.com", "b@example.com"})
+	p, ok := previous["a@example.com"]
+	if !ok || p.code != "NULL_MX" || p.deliverability != "undeliverable" {
+		t.Errorf("readPrevious = %+v, want the cached NULL_MX", p)
+	}
+	if _, ok := previous["b@example.com"]; ok {
+		t.Error("readPrevious returned a verdict for an email never cached")
+	}
+	if _, ok := eCache.get("a@example.com"); !ok {
+		t.Error("readPrevious removed the baseline from the cache")
+	}
+
+	eCache.add("a@example.com", "OK", "OK", time.Hour)
+	if item, _ := eCache.get("a@example.com"); item.code != "OK" {
+		t.Errorf("a fresh verdict did not replace the cached one, got %s", item.code)
+	}
+}
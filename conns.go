@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// connPriority tells who gets a free smtp connection first
+type connPriority int
+
+const (
+	// connPrimary is for validating the emails the clients asked for
+	connPrimary connPriority = iota
+	// connAuxiliary is for the extra probes, like the catch-all detection
+	connAuxiliary
+)
+
+// connScheduler shares the smtp.maxconnections budget between all the kinds of probes.
+// a connection that frees up goes to a waiting primary validation before any auxiliary probe
+type connScheduler struct {
+	sync.Mutex
+	free    int
+	waiting [2][]chan struct{}
+}
+
+func newConnScheduler(max int) *connScheduler {
+	return &connScheduler{free: max}
+}
+
+// acquire waits for a free connection, giving up when the context is done
+func (s *connScheduler) acquire(ctx context.Context, prio connPriority) error {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	queued := len(s.waiting[connPrimary])
+	if prio == connAuxiliary {
+		queued += len(s.waiting[connAuxiliary])
+	}
+	if s.free > 0 && queued == 0 {
+		s.free--
+		s.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	s.waiting[prio] = append(s.waiting[prio], ch)
+	s.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for i, w := range s.waiting[prio] {
+		if w == ch {
+			s.waiting[prio] = append(s.waiting[prio][:i], s.waiting[prio][i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// the connection was handed to us right when the context got done, pass it on
+	s.handOver()
+	return ctx.Err()
+}
+
+func (s *connScheduler) release() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.handOver()
+}
+
+// handOver gives the connection to the first waiting one, primary first
+func (s *connScheduler) handOver() {
+	for prio := range s.waiting {
+		if len(s.waiting[prio]) > 0 {
+			close(s.waiting[prio][0])
+			s.waiting[prio] = s.waiting[prio][1:]
+			return
+		}
+	}
+	s.free++
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+)
+
+// domainDispatcher feeds the workers with the emails of a batch one domain at a time, round robin,
+// keeping at most maxPerDomain emails of the same domain in flight, so a few slow domains
+// at the front of the batch cannot hold back the faster ones behind them
+type domainDispatcher struct {
+	sync.Mutex
+	cond         *sync.Cond
+	queues       map[string][]string
+	order        []string
+	inFlight     map[string]int
+	maxPerDomain int
+	pending      int
+	next         int
+}
+
+func newDomainDispatcher(emails []string, maxPerDomain int) *domainDispatcher {
+	d := &domainDispatcher{
+		queues:       make(map[string][]string),
+		inFlight:     make(map[string]int),
+		maxPerDomain: maxPerDomain,
+		pending:      len(emails),
+	}
+	d.cond = sync.NewCond(d)
+	for _, e := range emails {
+		dom := emailDomain(e)
+		if _, ok := d.queues[dom]; !ok {
+			d.order = append(d.order, dom)
+		}
+		d.queues[dom] = append(d.queues[dom], e)
+	}
+	return d
+}
+
+// feed sends all the emails to the work channel and closes it once done
+func (d *domainDispatcher) feed(work chan<- string) {
+	d.Lock()
+	for d.pending > 0 {
+		email := d.pick()
+		if len(email) == 0 {
+			// every domain with emails left is at its limit, wait for a worker to finish one
+			d.cond.Wait()
+			continue
+		}
+		d.Unlock()
+		work <- email
+		d.Lock()
+	}
+	d.Unlock()
+	close(work)
+}
+
+// pick returns the next email to send, taking the domains in turns
+func (d *domainDispatcher) pick() string {
+	for i := 0; i < len(d.order); i++ {
+		idx := (d.next + i) % len(d.order)
+		dom := d.order[idx]
+		q := d.queues[dom]
+		if len(q) == 0 || (d.maxPerDomain > 0 && d.inFlight[dom] >= d.maxPerDomain) {
+			continue
+		}
+		d.queues[dom] = q[1:]
+		d.inFlight[dom]++
+		d.pending--
+		d.next = idx + 1
+		return q[0]
+	}
+	return ""
+}
+
+// done tells the dispatcher a worker finished validating the email
+func (d *domainDispatcher) done(email string) {
+	d.Lock()
+	defer d.Unlock()
+	d.inFlight[emailDomain(email)]--
+	d.cond.Signal()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDomainDispatcherPick(t *testing.T) {
+	emails := []string{"1@slow.example", "2@slow.example", "3@slow.example", "4@slow.example", "1@a.example", "1@b.example"}
+	tests := []struct {
+		maxPerDomain int
+		want         []string
+	}{
+		{0, []string{"1@slow.example", "1@a.example", "1@b.example", "2@slow.example", "3@slow.example", "4@slow.example"}},
+		{2, []string{"1@slow.example", "1@a.example", "1@b.example", "2@slow.example", ""}},
+	}
+	for _, tt := range tests {
+		d := newDomainDispatcher(emails, tt.maxPerDomain)
+		var got []string
+		for range tt.want {
+			got = append(got, d.pick())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pick with max %d = %v, want %v", tt.maxPerDomain, got, tt.want)
+		}
+	}
+}
+
+// a domain never takes more workers than its share, and it is capped by default
+func TestDomainDispatcherFeed(t *testing.T) {
+	if newConfiguration().WorkDomainMaxWorkers < 1 {
+		t.Error("work.domain.maxworkers is off by default")
+	}
+
+	var emails []string
+	for i := 0; i < 50; i++ {
+		emails = append(emails, string(rune('a'+i%26))+"@slow.example")
+	}
+	d := newDomainDispatcher(emails, 3)
+	work := make(chan string)
+	go d.feed(work)
+
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	var wg sync.WaitGroup
+	for w := 0; w < 10; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				mu.Lock()
+				inFlight++
+				if inFlight > peak {
+					peak = inFlight
+				}
+				mu.Unlock()
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				d.done(e)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak > 3 {
+		t.Errorf("%d emails of the same domain in flight, want at most 3", peak)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"golang.org/x/net/dns/dnsmessage"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errDNSTruncated = errors.New("dns response truncated")
+
+// dnsSlots limits the dns lookups running at the same time to dns.maxconcurrent,
+// so a batch with lots of domains does not overwhelm the resolver. nil means no limit
+var dnsSlots chan struct{}
+
+// acquireDNS waits for a free dns lookup slot, giving up when the context is done
+func acquireDNS(ctx context.Context) error {
+	if dnsSlots == nil {
+		return nil
+	}
+	select {
+	case dnsSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseDNS() {
+	if dnsSlots != nil {
+		<-dnsSlots
+	}
+}
+
+// resolvConf is where the nameservers queried directly are read from
+var resolvConf = "/etc/resolv.conf"
+
+// resolvConfNameservers returns the nameservers of resolv.conf, in order
+func resolvConfNameservers() ([]string, error) {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no nameserver found in resolv.conf")
+	}
+	return servers, nil
+}
+
+// lookupMXTTL queries the nameservers directly for the mx records of the domain,
+// since the standard resolver does not expose their ttl. hasTTL is false when there is
+// no record to take the ttl from, a zero ttl is a ttl all the same.
+// the errors look like the ones of the standard resolver, so they can be handled the same way
+func lookupMXTTL(ctx context.Context, domainName string) (mxRecords []*net.MX, ttl time.Duration, hasTTL bool, err error) {
+	resp, server, err := queryNameserver(ctx, domainName, dnsmessage.TypeMX)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, false, &net.DNSError{Err: "no such host", Name: domainName, Server: server, IsNotFound: true}
+	default:
+		return nil, 0, false, &net.DNSError{Err: "server misbehaving", Name: domainName, Server: server, IsTemporary: resp.RCode == dnsmessage.RCodeServerFailure}
+	}
+
+	var minTTL uint32
+	for _, a := range resp.Answers {
+		mx, ok := a.Body.(*dnsmessage.MXResource)
+		if !ok {
+			continue
+		}
+		mxRecords = append(mxRecords, &net.MX{Host: mx.MX.String(), Pref: mx.Pref})
+		if !hasTTL || a.Header.TTL < minTTL {
+			minTTL = a.Header.TTL
+		}
+		hasTTL = true
+	}
+	sort.SliceStable(mxRecords, func(i, j int) bool {
+		return mxRecords[i].Pref < mxRecords[j].Pref
+	})
+
+	return mxRecords, time.Second * time.Duration(minTTL), hasTTL, nil
+}
+
+// queryNameserver sends a single question to the nameservers of resolv.conf and returns the first
+// answer, along with the nameserver for the errors. like the standard resolver, the next nameserver
+// is asked when one does not answer or fails, each getting its share of domains.mxquery.timeout
+func queryNameserver(ctx context.Context, domainName string, qtype dnsmessage.Type) (*dnsmessage.Message, string, error) {
+	servers, err := resolvConfNameservers()
+	if err != nil {
+		return nil, "", err
+	}
+
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domainName, ".") + ".")
+	if err != nil {
+		return nil, servers[0], err
+	}
+
+	// EDNS0 lets the answers with many records come over udp, up to the size of our buffer
+	var opt dnsmessage.ResourceHeader
+	if err = opt.SetEDNS0(dnsBufferSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, servers[0], err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions:   []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+		Additionals: []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, servers[0], err
+	}
+
+	timeout := time.Second * time.Duration(config.DomainsMXQueryTimeout) / time.Duration(len(servers))
+	var resp *dnsmessage.Message
+	var server string
+	for _, server = range servers {
+		resp, err = exchangeDNS(ctx, server, packed, id, domainName, timeout)
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil && resp.RCode != dnsmessage.RCodeServerFailure && resp.RCode != dnsmessage.RCodeRefused {
+			break
+		}
+	}
+	return resp, server, err
+}
+
+// dnsBufferSize is the size of the udp answers we take
+const dnsBufferSize = 4096
+
+// dnsDialAddr is the address dialed for the nameserver, the tests point it to their own servers
+var dnsDialAddr = func(server string) string { return server }
+
+// exchangeDNS sends the packed question to the nameserver and waits for its answer
+func exchangeDNS(ctx context.Context, server string, packed []byte, id uint16, domainName string, timeout time.Duration) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", dnsDialAddr(server))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err = conn.Write(packed); err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: domainName, Server: server, IsTimeout: isTimeout(err)}
+	}
+
+	buf := make([]byte, dnsBufferSize)
+	var resp dnsmessage.Message
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, &net.DNSError{Err: err.Error(), Name: domainName, Server: server, IsTimeout: isTimeout(err)}
+		}
+		if err = resp.Unpack(buf[:n]); err == nil && resp.ID == id {
+			break
+		}
+	}
+
+	if resp.Truncated {
+		return nil, errDNSTruncated
+	}
+	return &resp, nil
+}
+
+// domainExists tells a domain which does not exist at all, NXDOMAIN, from one which exists but
+// has no records of the type asked for, NODATA. the standard resolver reports both as not found
+func domainExists(ctx context.Context, domainName string) (bool, error) {
+	if err := acquireDNS(ctx); err != nil {
+		return false, err
+	}
+	defer releaseDNS()
+	resp, server, err := queryNameserver(ctx, domainName, dnsmessage.TypeA)
+	if err != nil {
+		return false, err
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+		return true, nil
+	case dnsmessage.RCodeNameError:
+		return false, nil
+	}
+	return false, &net.DNSError{Err: "server misbehaving", Name: domainName, Server: server, IsTemporary: true}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// hostIPsCache keeps the addresses the mx hosts resolve to,
+// so the emails of the same domain don't resolve the same hosts over and over
+type hostIPsCache struct {
+	sync.Mutex
+	ttl  time.Duration
+	data map[string]hostIPsItem
+}
+
+type hostIPsItem struct {
+	ips       []net.IP
+	expiresAt time.Time
+}
+
+// lookup returns the A and AAAA addresses of the host, from cache when possible
+func (c *hostIPsCache) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if scope := optionsFrom(ctx).scope; scope != nil && c != scope.hostIPs {
+		return scope.hostIPs.lookup(ctx, host)
+	}
+	if c != nil {
+		c.Lock()
+		item, ok := c.data[host]
+		c.Unlock()
+		if ok && time.Now().Before(item.expiresAt) {
+			return item.ips, nil
+		}
+	}
+
+	if err := acquireDNS(ctx); err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	releaseDNS()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+
+	if c != nil {
+		c.Lock()
+		c.data[host] = hostIPsItem{ips: ips, expiresAt: time.Now().Add(c.ttl)}
+		c.Unlock()
+	}
+	return ips, nil
+}
+
+func (c *hostIPsCache) gcHandler() {
+	ticker := time.NewTicker(c.ttl)
+	for _ = range ticker.C {
+		now := time.Now()
+		c.Lock()
+		for k, item := range c.data {
+			if now.After(item.expiresAt) {
+				delete(c.data, k)
+			}
+		}
+		c.Unlock()
+	}
+}
+
+func newHostIPsCache(ttl time.Duration) *hostIPsCache {
+	c := &hostIPsCache{ttl: ttl, data: make(map[string]hostIPsItem)}
+	go c.gcHandler()
+	return c
+}
+
+// mxLookups lets the concurrent lookups of the same domain share a single dns query.
+// the result is kept for a short while after it arrives, so the late arrivals of a burst
+// reuse it too, even with the mx cache disabled or cold
+type mxLookups struct {
+	sync.Mutex
+	wait    time.Duration
+	timeout time.Duration
+	calls   map[string]*mxLookupCall
+}
+
+type mxLookupCall struct {
+	done    chan struct{}
+	records []*net.MX
+	err     error
+}
+
+// join returns the lookup in progress, or just done, for the domain and whether
+// the caller has to do it itself because there is none
+func (l *mxLookups) join(domainName string) (*mxLookupCall, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if call, ok := l.calls[domainName]; ok {
+		return call, false
+	}
+	call := &mxLookupCall{done: make(chan struct{})}
+	l.calls[domainName] = call
+	return call, true
+}
+
+// finish hands the result to the ones waiting for it and forgets it after a while
+func (l *mxLookups) finish(domainName string, call *mxLookupCall, records []*net.MX, err error) {
+	call.records, call.err = records, err
+	close(call.done)
+	time.AfterFunc(l.wait, func() {
+		l.Lock()
+		if l.calls[domainName] == call {
+			delete(l.calls, domainName)
+		}
+		l.Unlock()
+	})
+}
+
+// errMXLookupAborted is handed to the ones waiting when the query panicked
+var errMXLookupAborted = errors.New("mx lookup aborted")
+
+// lookup does the query of the call owned by the caller. the result is handed over even when
+// the query panics, the panic is recovered further up, so the others don't wait in vain
+func (l *mxLookups) lookup(ctx context.Context, domainName string, call *mxLookupCall, query func(context.Context, string) ([]*net.MX, error)) (records []*net.MX, err error) {
+	err = errMXLookupAborted
+	defer func() { l.finish(domainName, call, records, err) }()
+	return query(ctx, domainName)
+}
+
+// await waits for the lookup done by someone else, as long as the query itself may take.
+// false means it took even longer or failed because of the other request or its panic, so better do it ourselves
+func (l *mxLookups) await(ctx context.Context, call *mxLookupCall) bool {
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+		return !errors.Is(call.err, context.Canceled) && !errors.Is(call.err, context.DeadlineExceeded) && call.err != errMXLookupAborted
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func newMXLookups(wait, timeout time.Duration) *mxLookups {
+	return &mxLookups{wait: wait, timeout: timeout, calls: make(map[string]*mxLookupCall)}
+}
+
+// reverseDNS is the ptr of an address and whether it is forward confirmed,
+// that is whether the name it points to resolves back to the same address
+type reverseDNS struct {
+	ptr       string
+	confirmed bool
+	expiresAt time.Time
+}
+
+// reverseDNSCache keeps the reverse dns of the mx host addresses
+type reverseDNSCache struct {
+	sync.Mutex
+	ttl  time.Duration
+	data map[string]reverseDNS
+}
+
+// lookup returns the reverse dns of the address, from cache when possible
+func (c *reverseDNSCache) lookup(ctx context.Context, ip string) (reverseDNS, error) {
+	if scope := optionsFrom(ctx).scope; scope != nil && c != scope.rdns {
+		return scope.rdns.lookup(ctx, ip)
+	}
+	if c != nil {
+		c.Lock()
+		item, ok := c.data[ip]
+		c.Unlock()
+		if ok && time.Now().Before(item.expiresAt) {
+			return item, nil
+		}
+	}
+
+	if err := acquireDNS(ctx); err != nil {
+		return reverseDNS{}, err
+	}
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	releaseDNS()
+	if err != nil {
+		return reverseDNS{}, err
+	}
+	var rdns reverseDNS
+	for i, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if i == 0 {
+			rdns.ptr = name
+		}
+		ips, err := hostIPs.lookup(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, a := range ips {
+			if a.String() == ip {
+				rdns.ptr, rdns.confirmed = name, true
+				break
+			}
+		}
+		if rdns.confirmed {
+			break
+		}
+	}
+
+	if c != nil {
+		rdns.expiresAt = time.Now().Add(c.ttl)
+		c.Lock()
+		c.data[ip] = rdns
+		c.Unlock()
+	}
+	return rdns, nil
+}
+
+func (c *reverseDNSCache) gcHandler() {
+	ticker := time.NewTicker(c.ttl)
+	for _ = range ticker.C {
+		now := time.Now()
+		c.Lock()
+		for k, item := range c.data {
+			if now.After(item.expiresAt) {
+				delete(c.data, k)
+			}
+		}
+		c.Unlock()
+	}
+}
+
+func newReverseDNSCache(ttl time.Duration) *reverseDNSCache {
+	c := &reverseDNSCache{ttl: ttl, data: make(map[string]reverseDNS)}
+	go c.gcHandler()
+	return c
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS answers the mx questions with the records, or with the rcode when it is not a success.
+// a silent one never answers
+type fakeDNS struct {
+	conn   net.PacketConn
+	rcode  dnsmessage.RCode
+	silent bool
+	ttls   []uint32
+	// edns tells the last question had an EDNS0 record, set by the server goroutine
+	edns atomic.Bool
+}
+
+func startFakeDNS(t *testing.T, f *fakeDNS) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	f.conn = conn
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if q.Unpack(buf[:n]) != nil || f.silent {
+				continue
+			}
+			f.edns.Store(len(q.Additionals) > 0 && q.Additionals[0].Header.Type == dnsmessage.TypeOPT)
+			resp := dnsmessage.Message{Header: dnsmessage.Header{ID: q.ID, Response: true, RCode: f.rcode}, Questions: q.Questions}
+			for i, ttl := range f.ttls {
+				mx, _ := dnsmessage.NewName("mx" + string(rune('1'+i)) + ".example.com.")
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeMX, Class: dnsmessage.ClassINET, TTL: ttl},
+					Body:   &dnsmessage.MXResource{Pref: uint16(10 * (i + 1)), MX: mx},
+				})
+			}
+			packed, _ := resp.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return f
+}
+
+// useNameservers points resolv.conf to the fake servers for the test. they all listen on
+// port 53 of their address in resolv.conf, so they are given as ip literals of the loopback
+// and the port is patched in the dial through dnsPorts
+func useNameservers(t *testing.T, servers ...*fakeDNS) {
+	var lines []string
+	ports := make(map[string]string)
+	for i, s := range servers {
+		ip := "127.0.0." + string(rune('1'+i))
+		lines = append(lines, "nameserver "+ip)
+		_, port, _ := net.SplitHostPort(s.conn.LocalAddr().String())
+		ports[net.JoinHostPort(ip, "53")] = net.JoinHostPort("127.0.0.1", port)
+	}
+	file := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prevConf, prevDial := resolvConf, dnsDialAddr
+	resolvConf = file
+	dnsDialAddr = func(server string) string { return ports[server] }
+	t.Cleanup(func() { resolvConf, dnsDialAddr = prevConf, prevDial })
+}
+
+func TestLookupMXTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []*fakeDNS
+		ttl     time.Duration
+		hasTTL  bool
+		records int
+		errText string
+	}{
+		{"single", []*fakeDNS{{ttls: []uint32{300, 60}}}, time.Minute, true, 2, ""},
+		{"zero ttl", []*fakeDNS{{ttls: []uint32{0, 300}}}, 0, true, 2, ""},
+		{"no records", []*fakeDNS{{}}, 0, false, 0, ""},
+		{"nxdomain", []*fakeDNS{{rcode: dnsmessage.RCodeNameError}}, 0, false, 0, "no such host"},
+		{"servfail, next answers", []*fakeDNS{{rcode: dnsmessage.RCodeServerFailure}, {ttls: []uint32{120}}}, 2 * time.Minute, true, 1, ""},
+		{"silent, next answers", []*fakeDNS{{silent: true}, {ttls: []uint32{120}}}, 2 * time.Minute, true, 1, ""},
+		{"all servfail", []*fakeDNS{{rcode: dnsmessage.RCodeServerFailure}, {rcode: dnsmessage.RCodeServerFailure}}, 0, false, 0, "server misbehaving"},
+	}
+	defer func(timeout int) { config.DomainsMXQueryTimeout = timeout }(config.DomainsMXQueryTimeout)
+	config.DomainsMXQueryTimeout = 2
+	for _, tt := range tests {
+		for _, s := range tt.servers {
+			startFakeDNS(t, s)
+		}
+		useNameservers(t, tt.servers...)
+		records, ttl, hasTTL, err := lookupMXTTL(context.Background(), "example.com")
+		if len(tt.errText) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.errText)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(records) != tt.records || ttl != tt.ttl || hasTTL != tt.hasTTL {
+			t.Errorf("%s: %d records ttl %s has %v, want %d ttl %s has %v", tt.name, len(records), ttl, hasTTL, tt.records, tt.ttl, tt.hasTTL)
+		}
+		if !tt.servers[len(tt.servers)-1].edns.Load() {
+			t.Errorf("%s: the question had no EDNS0 record", tt.name)
+		}
+	}
+}
+
+// an item is only replaced once it expired, a fresh one stays
+func TestMXCacheRemoveExpired(t *testing.T) {
+	tests := []struct {
+		expiresAt time.Time
+		removed   bool
+	}{
+		{time.Time{}, false},
+		{time.Now().Add(time.Hour), false},
+		{time.Now().Add(-time.Second), true},
+	}
+	for _, tt := range tests {
+		d := &domainsMXCache{maxSize: 10}
+		d.data = append(d.data, &domainsMXCacheDataItem{key: "example.com", expiresAt: tt.expiresAt})
+		d.removeExpired("example.com")
+		if removed := len(d.data) == 0; removed != tt.removed {
+			t.Errorf("removeExpired with expiry %v removed %v, want %v", tt.expiresAt, removed, tt.removed)
+		}
+	}
+}
+
+// a nameserver failing to answer is a temporary dns failure, never an internal error nor cached
+func TestValidateEmailDNSFailure(t *testing.T) {
+	defer func(mxCache, useTTL, enabled bool, timeout int, policy string) {
+		config.DomainsMXCacheEnabled, config.DomainsMXCacheUseTTL, config.EmailsCacheEnabled, config.DomainsMXQueryTimeout = mxCache, useTTL, enabled, timeout
+		config.InternalErrorPolicy = policy
+	}(config.DomainsMXCacheEnabled, config.DomainsMXCacheUseTTL, config.EmailsCacheEnabled, config.DomainsMXQueryTimeout, config.InternalErrorPolicy)
+	config.DomainsMXCacheEnabled, config.DomainsMXCacheUseTTL, config.EmailsCacheEnabled, config.DomainsMXQueryTimeout = false, true, true, 2
+	config.InternalErrorPolicy = "invalid"
+	useNameservers(t, startFakeDNS(t, &fakeDNS{rcode: dnsmessage.RCodeServerFailure}))
+
+	res := &emailResult{}
+	verdict := validateEmail(context.Background(), "someone@broken.example", res)
+	if !strings.HasPrefix(verdict, "unknown (dns failure)") || res.ReasonCode != "DNS_FAILURE" || res.Deliverability != "unknown" {
+		t.Fatalf("validateEmail = %q %s %s, want unknown (dns failure) DNS_FAILURE unknown", verdict, res.ReasonCode, res.Deliverability)
+	}
+	if _, ok := eCache.get("someone@broken.example"); ok {
+		t.Error("the dns failure was cached")
+	}
+}
+
+// a domain which does not exist goes through the same interpretation and cache as the other verdicts
+func TestValidateEmailNoSuchDomain(t *testing.T) {
+	defer func(mxCache, useTTL, enabled bool, timeout int) {
+		config.DomainsMXCacheEnabled, config.DomainsMXCacheUseTTL, config.EmailsCacheEnabled, config.DomainsMXQueryTimeout = mxCache, useTTL, enabled, timeout
+	}(config.DomainsMXCacheEnabled, config.DomainsMXCacheUseTTL, config.EmailsCacheEnabled, config.DomainsMXQueryTimeout)
+	config.DomainsMXCacheEnabled, config.DomainsMXCacheUseTTL, config.EmailsCacheEnabled, config.DomainsMXQueryTimeout = false, true, true, 2
+	useNameservers(t, startFakeDNS(t, &fakeDNS{rcode: dnsmessage.RCodeNameError}))
+	defer eCache.remove("someone@nowhere.example")
+
+	res := &emailResult{}
+	verdict := validateEmail(context.Background(), "someone@nowhere.example", res)
+	if verdict != "no such domain" || res.ReasonCode != "NO_SUCH_DOMAIN" || res.Deliverability != "undeliverable" {
+		t.Fatalf("validateEmail = %q %s %s, want no such domain NO_SUCH_DOMAIN undeliverable", verdict, res.ReasonCode, res.Deliverability)
+	}
+	if item, ok := eCache.get("someone@nowhere.example"); !ok || item.code != "NO_SUCH_DOMAIN" {
+		t.Errorf("the verdict was not cached: %+v %v", item, ok)
+	}
+}
+
+// the ones joining a lookup wait for it as long as the query may take, not just the reuse window
+func TestMXLookupsAwait(t *testing.T) {
+	tests := []struct {
+		name   string
+		delay  time.Duration
+		err    error
+		reused bool
+	}{
+		{"done within the reuse window", 0, nil, true},
+		{"done after the reuse window", 50 * time.Millisecond, nil, true},
+		{"failed with a dns error", 0, errDNSUnavailable, true},
+		{"canceled by its owner", 0, context.Canceled, false},
+		{"slower than the query timeout", 300 * time.Millisecond, nil, false},
+	}
+	for _, tt := range tests {
+		l := newMXLookups(10*time.Millisecond, 200*time.Millisecond)
+		call, own := l.join("example.com")
+		if _, again := l.join("example.com"); !own || again {
+			t.Fatalf("%s: join own %v, again %v", tt.name, own, again)
+		}
+		time.AfterFunc(tt.delay, func() { l.finish("example.com", call, nil, tt.err) })
+		if reused := l.await(context.Background(), call); reused != tt.reused {
+			t.Errorf("%s: await = %v, want %v", tt.name, reused, tt.reused)
+		}
+	}
+}
+
+// only the failures of the resolver itself open the breaker, the SERVFAIL of one domain does not
+func TestDNSCircuitRecord(t *testing.T) {
+	servfail := &net.DNSError{Err: "server misbehaving", Name: "broken.example", IsTemporary: true}
+	refused := &net.DNSError{Err: "server misbehaving", Name: "example.com"}
+	timeout := &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true, IsTemporary: true}
+	notFound := &net.DNSError{Err: "no such host", Name: "nowhere.example", IsNotFound: true}
+	tests := []struct {
+		name string
+		errs []error
+		open bool
+	}{
+		{"servfails", []error{servfail, servfail, servfail}, false},
+		{"timeouts", []error{timeout, timeout, timeout}, true},
+		{"refused", []error{refused, refused, refused}, true},
+		{"servfails between timeouts", []error{timeout, servfail, timeout, servfail, timeout}, true},
+		{"not found resets", []error{timeout, timeout, notFound, timeout}, false},
+		{"success resets", []error{timeout, timeout, nil, timeout}, false},
+	}
+	for _, tt := range tests {
+		d := newDNSCircuit(3, time.Minute)
+		for _, err := range tt.errs {
+			d.record(err)
+		}
+		if d.isOpen() != tt.open {
+			t.Errorf("%s: open %v, want %v", tt.name, d.isOpen(), tt.open)
+		}
+	}
+}
+
+// the waiters get the result of the owner, or do their own lookup when its query panicked
+func TestMXLookupsLookup(t *testing.T) {
+	records := []*net.MX{{Host: "mx.example.com.", Pref: 10}}
+	tests := []struct {
+		name    string
+		query   func(context.Context, string) ([]*net.MX, error)
+		records int
+		err     error
+		reused  bool
+	}{
+		{"found", func(context.Context, string) ([]*net.MX, error) { return records, nil }, 1, nil, true},
+		{"dns error", func(context.Context, string) ([]*net.MX, error) { return nil, errDNSUnavailable }, 0, errDNSUnavailable, true},
+		{"panic", func(context.Context, string) ([]*net.MX, error) { panic("boom") }, 0, errMXLookupAborted, false},
+	}
+	for _, tt := range tests {
+		l := newMXLookups(time.Minute, time.Second)
+		call, _ := l.join("example.com")
+		func() {
+			defer func() { recover() }()
+			l.lookup(context.Background(), "example.com", call, tt.query)
+		}()
+
+		select {
+		case <-call.done:
+		default:
+			t.Fatalf("%s: the call was not finished", tt.name)
+		}
+		if len(call.records) != tt.records || call.err != tt.err {
+			t.Errorf("%s: call got %d records, %v, want %d, %v", tt.name, len(call.records), call.err, tt.records, tt.err)
+		}
+		if reused := l.await(context.Background(), call); reused != tt.reused {
+			t.Errorf("%s: await = %v, want %v", tt.name, reused, tt.reused)
+		}
+	}
+}
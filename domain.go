@@ -0,0 +1,20 @@
+package main
+
+import (
+	"golang.org/x/net/idna"
+	"strings"
+)
+
+// normalizeDomain returns the canonical form of the domain of an email, lowercased, without
+// the trailing dot and in punycode, so User@Example.Com. gives example.com and münchen.de xn--mnchen-3ya.de.
+// ip literals are only lowercased, the domains idna refuses keep their lowercased form
+func normalizeDomain(domain string) string {
+	domain = strings.TrimRight(strings.ToLower(domain), ".")
+	if strings.HasPrefix(domain, "[") {
+		return domain
+	}
+	if ascii, err := idna.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return domain
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// domainsList is a set of domains safe for concurrent use, optionally backed by a file holding one
+// domain per line. a line starting with - removes the domain, like one given with addCSV and deleted since
+type domainsList struct {
+	sync.RWMutex
+	file   string
+	data   map[string]bool
+	base   []string
+	saveMu sync.Mutex
+}
+
+func newDomainsList(file string) *domainsList {
+	return &domainsList{
+		file: file,
+		data: make(map[string]bool),
+	}
+}
+
+func (d *domainsList) has(dom string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	return d.data[dom]
+}
+
+// matches is like has, but also accepts the wildcard entries: *.example.com matches a.example.com
+func (d *domainsList) matches(dom string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	if d.data[dom] {
+		return true
+	}
+	for strings.Contains(dom, ".") {
+		dom = dom[strings.Index(dom, ".")+1:]
+		if d.data["*."+dom] {
+			return true
+		}
+	}
+	return false
+}
+
+// addCSV adds the domains separated by a comma: a.com,b.com,c.com
+// they are kept when the list is reloaded from its file
+func (d *domainsList) addCSV(csv string) {
+	if len(csv) == 0 {
+		return
+	}
+	doms := strings.Split(csv, ",")
+	d.base = append(d.base, doms...)
+	d.add(doms)
+}
+
+func (d *domainsList) add(doms []string) {
+	d.Lock()
+	defer d.Unlock()
+	for _, dom := range doms {
+		dom = strings.ToLower(strings.TrimSpace(dom))
+		if len(dom) > 0 {
+			d.data[dom] = true
+		}
+	}
+}
+
+func (d *domainsList) remove(doms []string) {
+	d.Lock()
+	defer d.Unlock()
+	for _, dom := range doms {
+		delete(d.data, strings.ToLower(strings.TrimSpace(dom)))
+	}
+}
+
+// list returns the sorted domains
+func (d *domainsList) list() []string {
+	d.RLock()
+	defer d.RUnlock()
+	doms := make([]string, 0, len(d.data))
+	for dom := range d.data {
+		doms = append(doms, dom)
+	}
+	sort.Strings(doms)
+	return doms
+}
+
+// load adds the domains from the backing file, a missing file is not an error
+func (d *domainsList) load() error {
+	doms, err := d.read()
+	if err != nil {
+		return err
+	}
+	d.apply(doms)
+	return nil
+}
+
+// apply adds the domains of the lines read from the file and removes the ones starting with -
+func (d *domainsList) apply(lines []string) {
+	var doms, removed []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "-") {
+			removed = append(removed, line[1:])
+		} else {
+			doms = append(doms, line)
+		}
+	}
+	d.add(doms)
+	d.remove(removed)
+}
+
+// reload replaces the domains with the ones of the backing file, plus the ones given with addCSV
+func (d *domainsList) reload() error {
+	doms, err := d.read()
+	if err != nil {
+		return err
+	}
+	fresh := newDomainsList("")
+	fresh.add(d.base)
+	fresh.apply(doms)
+
+	d.Lock()
+	d.data = fresh.data
+	d.Unlock()
+	return nil
+}
+
+// watch reloads the list whenever its backing file changes, checking every interval
+func (d *domainsList) watch(interval time.Duration, onError func(error)) {
+	if len(d.file) == 0 {
+		return
+	}
+	var modTime time.Time
+	if fi, err := os.Stat(d.file); err == nil {
+		modTime = fi.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	for _ = range ticker.C {
+		fi, err := os.Stat(d.file)
+		if err != nil || fi.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+		if err = d.reload(); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// read returns the domains of the backing file, skipping the empty lines and the # comments
+func (d *domainsList) read() ([]string, error) {
+	if len(d.file) == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(d.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var doms []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			doms = append(doms, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return doms, nil
+}
+
+// save writes the domains to the backing file, replacing it atomically. only the changes to the ones
+// given with addCSV are written, the domains added and the removed ones with a -, so a domain
+// removed at runtime stays removed across restarts
+func (d *domainsList) save() error {
+	if len(d.file) == 0 {
+		return nil
+	}
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.file), filepath.Base(d.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, line := range d.changes() {
+		w.WriteString(line + "\n")
+	}
+	if err = w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.file)
+}
+
+// changes returns the domains which are not among the ones given with addCSV, and those of them
+// which are gone, with a -
+func (d *domainsList) changes() []string {
+	base := newDomainsList("")
+	base.add(d.base)
+
+	var lines []string
+	for _, dom := range d.list() {
+		if !base.has(dom) {
+			lines = append(lines, dom)
+		}
+	}
+	for _, dom := range base.list() {
+		if !d.has(dom) {
+			lines = append(lines, "-"+dom)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the changes made at runtime survive a restart, the removed domains given with addCSV included
+func TestDomainsListSave(t *testing.T) {
+	tests := []struct {
+		name    string
+		add     []string
+		remove  []string
+		file    []string
+		restart []string
+	}{
+		{"nothing changed", nil, nil, nil, []string{"a.example", "b.example"}},
+		{"added", []string{"c.example"}, nil, []string{"c.example"}, []string{"a.example", "b.example", "c.example"}},
+		{"removed", nil, []string{"a.example"}, []string{"-a.example"}, []string{"b.example"}},
+		{"added and removed", []string{"c.example", "d.example"}, []string{"b.example", "d.example"}, []string{"c.example", "-b.example"}, []string{"a.example", "c.example"}},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "blacklist.txt")
+		d := newDomainsList(file)
+		d.addCSV("a.example,b.example")
+		if err := d.load(); err != nil {
+			t.Fatal(err)
+		}
+		d.add(tt.add)
+		d.remove(tt.remove)
+		if err := d.save(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Fields(string(b)); !reflect.DeepEqual(got, tt.file) && (len(got) > 0 || len(tt.file) > 0) {
+			t.Errorf("%s: file has %v, want %v", tt.name, got, tt.file)
+		}
+
+		restarted := newDomainsList(file)
+		restarted.addCSV("a.example,b.example")
+		if err := restarted.load(); err != nil {
+			t.Fatal(err)
+		}
+		if got := restarted.list(); !reflect.DeepEqual(got, tt.restart) {
+			t.Errorf("%s: after a restart %v, want %v", tt.name, got, tt.restart)
+		}
+		if err := restarted.reload(); err != nil {
+			t.Fatal(err)
+		}
+		if got := restarted.list(); !reflect.DeepEqual(got, tt.restart) {
+			t.Errorf("%s: after a reload %v, want %v", tt.name, got, tt.restart)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pathStep is one step of the emails path, an object key, an array index or [*] for all the items of an array
+type pathStep struct {
+	key   string
+	index int
+	all   bool
+}
+
+// parseEmailsPath parses a path like data.contacts[*].email into its steps
+func parseEmailsPath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+		}
+		if len(key) > 0 {
+			steps = append(steps, pathStep{key: key})
+		} else if len(part) == 0 || part[0] != '[' {
+			return nil, fmt.Errorf("empty key in %q", path)
+		}
+
+		for rest := part[len(key):]; len(rest) > 0; {
+			end := strings.Index(rest, "]")
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("malformed index in %q", path)
+			}
+			idx := rest[1:end]
+			if idx == "*" {
+				steps = append(steps, pathStep{all: true})
+			} else if n, err := strconv.Atoi(idx); err == nil && n >= 0 {
+				steps = append(steps, pathStep{index: n})
+			} else {
+				return nil, fmt.Errorf("invalid index %q in %q", idx, path)
+			}
+			rest = rest[end+1:]
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty path %q", path)
+	}
+	return steps, nil
+}
+
+// readEmailsAt walks the posted json object one token at a time and takes the emails found at the given path.
+// a path ending on an array takes its strings, anything else which is not a string is skipped.
+// only the emails are kept, and the payload is rejected as soon as they go over request.maxemails
+func readEmailsAt(body io.Reader, steps []pathStep) (incomingEmails, error) {
+	dec := json.NewDecoder(body)
+	t, err := dec.Token()
+	if err == io.EOF {
+		return nil, errEmptyPayload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var iem incomingEmails
+	if err := collectEmails(dec, t, steps, &iem); err != nil {
+		return nil, err
+	}
+	return iem, nil
+}
+
+// collectEmails takes the emails of the value starting with the token t, reading the rest of it from dec
+func collectEmails(dec *json.Decoder, t json.Token, steps []pathStep, iem *incomingEmails) error {
+	if len(steps) == 0 {
+		switch t := t.(type) {
+		case string:
+			return addEmail(iem, t)
+		case json.Delim:
+			if t != '[' {
+				return skipValue(dec, t)
+			}
+			for dec.More() {
+				item, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if e, ok := item.(string); ok {
+					if err := addEmail(iem, e); err != nil {
+						return err
+					}
+				} else if err := skipValue(dec, item); err != nil {
+					return err
+				}
+			}
+			_, err := dec.Token()
+			return err
+		}
+		return nil
+	}
+
+	step := steps[0]
+	switch t {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			value, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if len(step.key) > 0 && key == step.key {
+				err = collectEmails(dec, value, steps[1:], iem)
+			} else {
+				err = skipValue(dec, value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			item, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if step.all || (len(step.key) == 0 && step.index == i) {
+				err = collectEmails(dec, item, steps[1:], iem)
+			} else {
+				err = skipValue(dec, item)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func addEmail(iem *incomingEmails, e string) error {
+	if config.RequestMaxEmails > 0 && len(*iem) >= config.RequestMaxEmails {
+		return errTooManyEmails
+	}
+	*iem = append(*iem, e)
+	return nil
+}
+
+// skipValue reads the rest of the value starting with the token t, without keeping any of it
+func skipValue(dec *json.Decoder, t json.Token) error {
+	if t != json.Delim('{') && t != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
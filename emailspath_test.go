@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadEmailsAt(t *testing.T) {
+	tests := []struct {
+		path string
+		body string
+		want incomingEmails
+		err  error
+	}{
+		{"emails", `{"emails": ["a@example.com", 1, {"x": "y"}, "b@example.com"]}`, incomingEmails{"a@example.com", "b@example.com"}, nil},
+		{"data.contacts[*].email", `{"meta": {"n": [1, [2]]}, "data": {"contacts": [{"email": "a@example.com", "name": "A"}, {"name": "B"}, {"email": "c@example.com"}]}}`, incomingEmails{"a@example.com", "c@example.com"}, nil},
+		{"list[1]", `{"list": ["a@example.com", "b@example.com", "c@example.com"]}`, incomingEmails{"b@example.com"}, nil},
+		{"[*].email", `[{"email": "a@example.com"}, {"email": ["b@example.com"]}]`, incomingEmails{"a@example.com", "b@example.com"}, nil},
+		{"emails", `{"other": ["a@example.com"]}`, nil, nil},
+		{"emails", `{"emails": "a@example.com"}`, incomingEmails{"a@example.com"}, nil},
+		{"emails", `{"emails": ["a@example.com", "b@example.com", "c@example.com", "d@example.com"]}`, nil, errTooManyEmails},
+		{"emails", ``, nil, errEmptyPayload},
+	}
+	defer func(max int) { config.RequestMaxEmails = max }(config.RequestMaxEmails)
+	config.RequestMaxEmails = 3
+	for _, tt := range tests {
+		steps, err := parseEmailsPath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readEmailsAt(strings.NewReader(tt.body), steps)
+		if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("readEmailsAt(%s, %s) = %v, %v, want %v, %v", tt.path, tt.body, got, err, tt.want, tt.err)
+		}
+	}
+
+	// a broken object is an error, not the emails found before it broke
+	steps, _ := parseEmailsPath("emails")
+	if got, err := readEmailsAt(strings.NewReader(`{"emails": ["a@example.com"`), steps); err == nil {
+		t.Errorf("readEmailsAt of a truncated object = %v, want an error", got)
+	}
+}
+
+func TestReadRequestEmailsMaxBytes(t *testing.T) {
+	defer func(max int) { config.RequestMaxBytes = max }(config.RequestMaxBytes)
+	config.RequestMaxBytes = 64
+	tests := []struct {
+		body string
+		err  error
+	}{
+		{`["a@example.com", "b@example.com"]`, nil},
+		{`["a@example.com", "b@example.com", "c@example.com", "d@example.com"]`, errPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		if _, err := readRequestEmails(r); err != tt.err {
+			t.Errorf("readRequestEmails(%s) error = %v, want %v", tt.body, err, tt.err)
+		}
+	}
+}
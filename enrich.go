@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// bannerConn keeps the first bytes read from the connection, which hold the server greeting
+type bannerConn struct {
+	net.Conn
+	buf []byte
+	// helo is set once the smtp client fell back to HELO, after the server rejected EHLO
+	helo bool
+	// ehlo is the last EHLO response read in the clear, before any STARTTLS, since the smtp
+	// client keeps only the extensions it knows of. reading is set while it is coming in
+	ehlo    []byte
+	reading bool
+}
+
+func (b *bannerConn) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte("HELO ")) {
+		b.helo = true
+	}
+	b.reading = bytes.HasPrefix(p, []byte("EHLO "))
+	if b.reading {
+		b.ehlo = b.ehlo[:0]
+	}
+	return b.Conn.Write(p)
+}
+
+func (b *bannerConn) Read(p []byte) (int, error) {
+	n, err := b.Conn.Read(p)
+	if len(b.buf) < 1024 {
+		b.buf = append(b.buf, p[:n]...)
+	}
+	if b.reading && len(b.ehlo) < 4096 {
+		b.ehlo = append(b.ehlo, p[:n]...)
+	}
+	return n, err
+}
+
+// extensions returns the keyword of every extension the EHLO response advertised, like SIZE or XCLIENT
+func (b *bannerConn) extensions() []string {
+	var exts []string
+	for i, line := range strings.Split(string(b.ehlo), "\n") {
+		line = strings.TrimSpace(line)
+		// the first line greets us, the extensions follow
+		if i == 0 || len(line) < 5 || !strings.HasPrefix(line, "250") {
+			continue
+		}
+		if fields := strings.Fields(line[4:]); len(fields) > 0 {
+			exts = append(exts, strings.ToUpper(fields[0]))
+		}
+	}
+	return exts
+}
+
+// banner returns the greeting lines, without the 220 code
+func (b *bannerConn) banner() string {
+	var lines []string
+	for _, line := range strings.Split(string(b.buf), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "220") {
+			break
+		}
+		lines = append(lines, strings.TrimSpace(line[3:]))
+		if !strings.HasPrefix(line, "220-") {
+			break
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// mailServerSignatures map the bits found in the smtp greetings to the mail server software
+var mailServerSignatures = []struct {
+	rx   *regexp.Regexp
+	name string
+}{
+	{regexp.MustCompile(`(?i)\bpostfix\b`), "Postfix"},
+	{regexp.MustCompile(`(?i)\bexim\b`), "Exim"},
+	{regexp.MustCompile(`(?i)\bsendmail\b`), "Sendmail"},
+	{regexp.MustCompile(`(?i)\bqmail\b`), "qmail"},
+	{regexp.MustCompile(`(?i)\bharaka\b`), "Haraka"},
+	{regexp.MustCompile(`(?i)\bopensmtpd\b`), "OpenSMTPD"},
+	{regexp.MustCompile(`(?i)\bzimbra\b`), "Zimbra"},
+	{regexp.MustCompile(`(?i)\bmdaemon\b`), "MDaemon"},
+	{regexp.MustCompile(`(?i)\bkerio\b`), "Kerio Connect"},
+	{regexp.MustCompile(`(?i)\bicewarp\b`), "IceWarp"},
+	{regexp.MustCompile(`(?i)\bhmailserver\b`), "hMailServer"},
+	{regexp.MustCompile(`(?i)\bcommunigate\b`), "CommuniGate Pro"},
+	{regexp.MustCompile(`(?i)\bcourier\b`), "Courier"},
+	{regexp.MustCompile(`(?i)\bdomino\b`), "IBM Domino"},
+	{regexp.MustCompile(`(?i)\bmimecast\b`), "Mimecast"},
+	{regexp.MustCompile(`(?i)\bproofpoint\b|\bpphosted\b`), "Proofpoint"},
+	{regexp.MustCompile(`(?i)\bbarracuda\b`), "Barracuda"},
+	{regexp.MustCompile(`(?i)\bgsmtp\b`), "Google"},
+	{regexp.MustCompile(`(?i)microsoft esmtp mail service|\bmicrosoft\b`), "Microsoft Exchange"},
+}
+
+// parseMailServer tells the mail server software from its greeting, empty if unknown
+func parseMailServer(banner string) string {
+	for _, s := range mailServerSignatures {
+		if s.rx.MatchString(banner) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// mailServers caches the mail server software per mx host
+type mailServers struct {
+	sync.Mutex
+	maxSize int
+	data    map[string]string
+}
+
+func (m *mailServers) get(host string) (string, bool) {
+	m.Lock()
+	defer m.Unlock()
+	v, ok := m.data[host]
+	return v, ok
+}
+
+func (m *mailServers) add(host, server string) {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.data) >= m.maxSize {
+		m.data = make(map[string]string)
+	}
+	m.data[host] = server
+}
+
+// detect returns the mail server software of the host, parsing its greeting only when not cached
+func (m *mailServers) detect(host, banner string) string {
+	if v, ok := m.get(host); ok {
+		return v
+	}
+	v := parseMailServer(banner)
+	m.add(host, v)
+	return v
+}
+
+func newMailServers() *mailServers {
+	return &mailServers{
+		maxSize: 10000,
+		data:    make(map[string]string),
+	}
+}
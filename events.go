@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"time"
+)
+
+// resultEvent is the message published for each validated email
+type resultEvent struct {
+	Email  string       `json:"email"`
+	Result *emailResult `json:"result"`
+	Time   time.Time    `json:"time"`
+}
+
+// eventsPublisher publishes the validation results to a nats jetstream subject.
+// results wait in a local buffer and are retried until the broker acknowledges them,
+// so transient broker outages do not lose anything, but the same result might be published twice
+type eventsPublisher struct {
+	subject string
+	queue   chan []byte
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+}
+
+var (
+	metricEventsPublished = expvar.NewInt("events.published")
+	metricEventsDropped   = expvar.NewInt("events.dropped")
+)
+
+func newEventsPublisher() (*eventsPublisher, error) {
+	nc, err := nats.Connect(config.EventsURL, nats.Name("evs-go"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	p := &eventsPublisher{
+		subject: config.EventsSubject,
+		queue:   make(chan []byte, config.EventsBufferSize),
+		nc:      nc,
+		js:      js,
+	}
+	go p.run()
+	return p, nil
+}
+
+// publish queues the result for publishing, it never blocks the worker,
+// if the local buffer is full the result is dropped
+func (p *eventsPublisher) publish(email string, res *emailResult) {
+	b, err := json.Marshal(&resultEvent{Email: email, Result: res, Time: time.Now()})
+	if err != nil {
+		return
+	}
+	select {
+	case p.queue <- b:
+	default:
+		metricEventsDropped.Add(1)
+		if config.Verbose {
+			fmt.Println("Events buffer is full, dropped the result of", logEmail(email))
+		}
+	}
+}
+
+func (p *eventsPublisher) run() {
+	for b := range p.queue {
+		for {
+			_, err := p.js.Publish(p.subject, b)
+			if err == nil {
+				metricEventsPublished.Add(1)
+				break
+			}
+			if config.Verbose {
+				fmt.Println("Events publish error, retrying:", err)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"testing"
+)
+
+func TestEventsPublish(t *testing.T) {
+	tests := []struct {
+		buffer      int
+		emails      []string
+		wantQueued  []string
+		wantDropped int64
+	}{
+		{2, []string{"a@example.com"}, []string{"a@example.com"}, 0},
+		{2, []string{"a@example.com", "b@example.com"}, []string{"a@example.com", "b@example.com"}, 0},
+		{2, []string{"a@example.com", "b@example.com", "c@example.com"}, []string{"a@example.com", "b@example.com"}, 1},
+		{0, []string{"a@example.com"}, nil, 1},
+	}
+	for _, tt := range tests {
+		p := &eventsPublisher{subject: "evs.results", queue: make(chan []byte, tt.buffer)}
+		dropped := metricEventsDropped.Value()
+		for _, e := range tt.emails {
+			p.publish(e, &emailResult{ReasonCode: "OK"})
+		}
+		close(p.queue)
+
+		var queued []string
+		for b := range p.queue {
+			var ev resultEvent
+			if err := json.Unmarshal(b, &ev); err != nil {
+				t.Fatalf("queued event %s: %v", b, err)
+			}
+			if ev.Result == nil || ev.Result.ReasonCode != "OK" || ev.Time.IsZero() {
+				t.Errorf("queued event %s lacks the result or the time", b)
+			}
+			queued = append(queued, ev.Email)
+		}
+		if len(queued) != len(tt.wantQueued) {
+			t.Errorf("publish(%v) with a buffer of %d queued %v, want %v", tt.emails, tt.buffer, queued, tt.wantQueued)
+		} else {
+			for i := range queued {
+				if queued[i] != tt.wantQueued[i] {
+					t.Errorf("publish(%v) with a buffer of %d queued %v, want %v", tt.emails, tt.buffer, queued, tt.wantQueued)
+					break
+				}
+			}
+		}
+		if got := metricEventsDropped.Value() - dropped; got != tt.wantDropped {
+			t.Errorf("publish(%v) with a buffer of %d dropped %d, want %d", tt.emails, tt.buffer, got, tt.wantDropped)
+		}
+	}
+}
+
+// fakeJetStream fails the first publishes of each message, then acknowledges it
+type fakeJetStream struct {
+	nats.JetStreamContext
+	mu        sync.Mutex
+	failures  int
+	attempts  map[string]int
+	published []string
+}
+
+func (f *fakeJetStream) Publish(subj string, data []byte, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts[string(data)]++
+	if f.attempts[string(data)] <= f.failures {
+		return nil, errors.New("nats: no responders available for request")
+	}
+	f.published = append(f.published, subj+" "+string(data))
+	return &nats.PubAck{}, nil
+}
+
+func TestEventsRun(t *testing.T) {
+	tests := []struct {
+		failures int
+		events   []string
+	}{
+		{0, []string{"a", "b"}},
+		{1, []string{"a"}},
+	}
+	for _, tt := range tests {
+		js := &fakeJetStream{failures: tt.failures, attempts: make(map[string]int)}
+		p := &eventsPublisher{subject: "evs.results", queue: make(chan []byte, len(tt.events)), js: js}
+		for _, e := range tt.events {
+			p.queue <- []byte(e)
+		}
+		close(p.queue)
+		p.run()
+
+		if len(js.published) != len(tt.events) {
+			t.Fatalf("run with %d failures published %v, want every one of %v", tt.failures, js.published, tt.events)
+		}
+		for i, e := range tt.events {
+			if js.published[i] != "evs.results "+e {
+				t.Errorf("run with %d failures published %q, want %q", tt.failures, js.published[i], "evs.results "+e)
+			}
+			if js.attempts[e] != tt.failures+1 {
+				t.Errorf("run with %d failures tried %q %d times, want %d", tt.failures, e, js.attempts[e], tt.failures+1)
+			}
+		}
+	}
+}
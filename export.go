@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// exportFormats are the formats the failures can be exported in
+var exportFormats = map[string]bool{"txt": true, "csv": true, "json": true}
+
+// exportFailures writes the emails that are not deliverable to a new file in export.dir
+// and returns its path. txt has only the emails, csv and json also the details of each result
+func exportFailures(o *outgoingEmails, format string) (string, error) {
+	if !exportFormats[format] {
+		return "", fmt.Errorf("unsupported export format: %q", format)
+	}
+
+	o.Lock()
+	var emails []string
+	for e, res := range o.Results {
+		if res.Deliverability != "deliverable" {
+			emails = append(emails, e)
+		}
+	}
+	o.Unlock()
+	sort.Strings(emails)
+
+	tmp, err := ioutil.TempFile(config.ExportDir, "evs-failures-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	switch format {
+	case "txt":
+		for _, e := range emails {
+			w.WriteString(e + "\n")
+		}
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"email", "deliverability", "reasonCode", "message"})
+		for _, e := range emails {
+			res := o.Results[e]
+			cw.Write([]string{e, res.Deliverability, res.ReasonCode, res.Message})
+		}
+		cw.Flush()
+		err = cw.Error()
+	case "json":
+		failures := make(map[string]*emailResult, len(emails))
+		for _, e := range emails {
+			failures[e] = o.Results[e]
+		}
+		err = json.NewEncoder(w).Encode(failures)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(config.ExportDir, fmt.Sprintf("evs-failures-%s.%s", time.Now().Format("20060102-150405.000000"), format))
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
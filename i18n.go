@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultReasonMessages is the catalog of the human readable reasons, by locale and reason code.
+// english is complete, the other locales fall back to it for whatever they miss
+var defaultReasonMessages = map[string]map[string]string{
+	"en": {
+		"OK":                 "The mailbox exists and accepts mail",
+		"INVALID_SYNTAX":     "The address is not a valid email address",
+		"LOCAL_TOO_LONG":     "The part of the address before the @ is too long",
+		"DOMAIN_TOO_LONG":    "The domain of the address is too long",
+		"ADDRESS_TOO_LONG":   "The address is too long",
+		"BLACKLISTED":        "The domain is blacklisted",
+		"HONEYPOT":           "The domain is a known spam trap",
+		"NO_MX":              "The domain has no mail servers",
+		"NO_MAIL_HOST":       "The domain exists but has no mail servers nor an address",
+		"NULL_MX":            "The domain does not accept mail",
+		"PRIVATE_MX":         "The mail servers of the domain point to private addresses",
+		"MX_MISCONFIGURED":   "The mail servers of the domain are misconfigured",
+		"MISSING_EXTENSIONS": "The mail server lacks required features",
+		"TLS_VERSION":        "The mail server does not support a secure enough connection",
+		"NO_SUCH_DOMAIN":     "The domain does not exist",
+		"TIMEOUT":            "The mail server did not answer in time",
+		"UNREACHABLE":        "The mail servers could not be reached",
+		"IPV6_UNREACHABLE":   "The mail servers are only reachable over ipv6, which the validation server lacks",
+		"MAILBOX_NOT_FOUND":  "The mailbox does not exist",
+		"MAILBOX_FULL":       "The mailbox exists but is full",
+		"MAILBOX_DISABLED":   "The mailbox is disabled",
+		"GREYLISTED":         "The mail server asked to try again later",
+		"RATE_LIMITED":       "The mail server is limiting our requests, try again later",
+		"SENDER_BLOCKED":     "The mail server refused to talk to us",
+		"DNS_FAILURE":        "The nameservers of the domain failed to answer, try again later",
+		"AUTH_REQUIRED":      "The mail server requires authentication, it is misconfigured",
+		"UNKNOWN":            "The mail server gave an unexpected answer",
+	},
+	"de": {
+		"OK":                "Das Postfach existiert und nimmt E-Mails an",
+		"INVALID_SYNTAX":    "Die Adresse ist keine gültige E-Mail-Adresse",
+		"NO_MX":             "Die Domain hat keine Mailserver",
+		"NO_SUCH_DOMAIN":    "Die Domain existiert nicht",
+		"TIMEOUT":           "Der Mailserver hat nicht rechtzeitig geantwortet",
+		"MAILBOX_NOT_FOUND": "Das Postfach existiert nicht",
+		"MAILBOX_FULL":      "Das Postfach existiert, ist aber voll",
+		"MAILBOX_DISABLED":  "Das Postfach ist deaktiviert",
+		"RATE_LIMITED":      "Der Mailserver begrenzt unsere Anfragen, später erneut versuchen",
+	},
+	"es": {
+		"OK":                "El buzón existe y acepta correo",
+		"INVALID_SYNTAX":    "La dirección no es una dirección de correo válida",
+		"NO_MX":             "El dominio no tiene servidores de correo",
+		"NO_SUCH_DOMAIN":    "El dominio no existe",
+		"TIMEOUT":           "El servidor de correo no respondió a tiempo",
+		"MAILBOX_NOT_FOUND": "El buzón no existe",
+		"MAILBOX_FULL":      "El buzón existe pero está lleno",
+		"MAILBOX_DISABLED":  "El buzón está desactivado",
+		"RATE_LIMITED":      "El servidor de correo limita nuestras consultas, inténtelo más tarde",
+	},
+	"fr": {
+		"OK":                "La boîte aux lettres existe et accepte le courrier",
+		"INVALID_SYNTAX":    "L'adresse n'est pas une adresse email valide",
+		"NO_MX":             "Le domaine n'a pas de serveur de messagerie",
+		"NO_SUCH_DOMAIN":    "Le domaine n'existe pas",
+		"TIMEOUT":           "Le serveur de messagerie n'a pas répondu à temps",
+		"MAILBOX_NOT_FOUND": "La boîte aux lettres n'existe pas",
+		"MAILBOX_FULL":      "La boîte aux lettres existe mais elle est pleine",
+		"MAILBOX_DISABLED":  "La boîte aux lettres est désactivée",
+		"RATE_LIMITED":      "Le serveur de messagerie limite nos requêtes, réessayez plus tard",
+	},
+	"uk": {
+		"OK":                "Поштова скринька існує та приймає листи",
+		"INVALID_SYNTAX":    "Адреса не є коректною адресою електронної пошти",
+		"NO_MX":             "Домен не має поштових серверів",
+		"NO_SUCH_DOMAIN":    "Домен не існує",
+		"TIMEOUT":           "Поштовий сервер не відповів вчасно",
+		"MAILBOX_NOT_FOUND": "Поштова скринька не існує",
+		"MAILBOX_FULL":      "Поштова скринька існує, але переповнена",
+		"MAILBOX_DISABLED":  "Поштова скринька вимкнена",
+		"RATE_LIMITED":      "Поштовий сервер обмежує наші запити, спробуйте пізніше",
+	},
+}
+
+// reasonMessage returns the reason code in words, in the given locale or reason.locale if none.
+// the messages of reason.messages come first, then the built in ones, then the english ones
+func reasonMessage(locale, code string) string {
+	if len(locale) == 0 {
+		locale = config.ReasonLocale
+	}
+	for _, catalog := range []map[string]map[string]string{config.ReasonMessages, defaultReasonMessages} {
+		if msg, ok := catalog[locale][code]; ok {
+			return msg
+		}
+	}
+	if msg, ok := config.ReasonMessages["en"][code]; ok {
+		return msg
+	}
+	return defaultReasonMessages["en"][code]
+}
+
+// hasLocale reports whether there are any messages for the locale
+func hasLocale(locale string) bool {
+	_, custom := config.ReasonMessages[locale]
+	_, builtin := defaultReasonMessages[locale]
+	return custom || builtin
+}
+
+// requestedLocale returns the locale asked for with ?lang= or else the first one of the
+// Accept-Language header we have messages for, trying de for de-AT too. empty if none
+func requestedLocale(r *http.Request) string {
+	tags := []string{r.URL.Query().Get("lang")}
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tags = append(tags, strings.Split(tag, ";")[0])
+	}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if len(tag) == 0 {
+			continue
+		}
+		if hasLocale(tag) {
+			return tag
+		}
+		if i := strings.Index(tag, "-"); i > 0 && hasLocale(tag[:i]) {
+			return tag[:i]
+		}
+	}
+	return ""
+}
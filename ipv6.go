@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+var (
+	ipv6Once  sync.Once
+	ipv6Route bool
+)
+
+// hasIPv6 tells whether we can reach the ipv6 internet, per smtp.ipv6. in auto mode it asks
+// the kernel for a route once, dialing udp sends nothing, it only picks the route and source address
+func hasIPv6() bool {
+	switch config.SMTPIPv6 {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	ipv6Once.Do(func() {
+		conn, err := net.Dial("udp6", "[2001:4860:4860::8888]:53")
+		if err == nil {
+			conn.Close()
+			ipv6Route = true
+		}
+	})
+	return ipv6Route
+}
+
+// mxIPv6Only reports whether the mx host resolves to ipv6 addresses only
+func mxIPv6Only(ctx context.Context, host string) bool {
+	ips, err := hostIPs.lookup(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return false
+		}
+	}
+	return true
+}
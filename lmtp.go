@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"net"
+)
+
+// lmtpConn turns the EHLO of the smtp client into the LHLO of LMTP, see RFC 2033, and so its
+// HELO fallback too, since LMTP has no HELO at all. the other commands used for probing
+// are the same in both protocols
+type lmtpConn struct {
+	net.Conn
+}
+
+func (l *lmtpConn) Write(b []byte) (int, error) {
+	if bytes.HasPrefix(b, []byte("EHLO ")) || bytes.HasPrefix(b, []byte("HELO ")) {
+		n, err := l.Conn.Write(append([]byte("LHLO "), b[5:]...))
+		if n > len(b) {
+			n = len(b)
+		}
+		return n, err
+	}
+	return l.Conn.Write(b)
+}
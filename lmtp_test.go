@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLMTPConnWrite(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"EHLO example.com\r\n", "LHLO example.com\r\n"},
+		{"HELO example.com\r\n", "LHLO example.com\r\n"},
+		{"MAIL FROM:<a@example.com>\r\n", "MAIL FROM:<a@example.com>\r\n"},
+		{"RCPT TO:<HELO@example.com>\r\n", "RCPT TO:<HELO@example.com>\r\n"},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go func() {
+			n, err := (&lmtpConn{client}).Write([]byte(tt.cmd))
+			if err != nil || n != len(tt.cmd) {
+				t.Errorf("Write(%q) = %d, %v", tt.cmd, n, err)
+			}
+			client.Close()
+		}()
+		buf := make([]byte, 128)
+		n, _ := server.Read(buf)
+		if got := string(buf[:n]); got != tt.want {
+			t.Errorf("Write(%q) sent %q, want %q", tt.cmd, got, tt.want)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"regexp"
+)
+
+// mailFromRule picks the MAIL FROM identity used when probing a given provider,
+// so each provider always sees the identity that has a good reputation with it
+type mailFromRule struct {
+	// Provider is matched against the domain of the email and against the mx host
+	Provider string `json:"provider"`
+	From     string `json:"from"`
+
+	providerRegex *regexp.Regexp
+}
+
+// compileMailFromRule compiles the regex of the rule
+func compileMailFromRule(rule *mailFromRule) (err error) {
+	rule.providerRegex, err = regexp.Compile(rule.Provider)
+	return err
+}
+
+// mailFrom returns the identity of the first rule matching the destination, email.from otherwise
+func mailFrom(domainName, mxHost string) string {
+	for _, rule := range config.MailFromRules {
+		if rule.providerRegex.MatchString(domainName) || rule.providerRegex.MatchString(mxHost) {
+			return rule.From
+		}
+	}
+	return config.CheckEmailFrom
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"expvar"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var metricMemoryPaused = expvar.NewInt("runtime.memory.paused")
+
+// cgroupMemoryFiles hold the memory limit of the container, cgroup v2 first then v1
+var cgroupMemoryFiles = []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"}
+
+// cgroupMemoryLimit returns the memory limit of the cgroup we run in, 0 when there is none.
+// v2 says max and v1 a number close to the largest int64 when unlimited
+func cgroupMemoryLimit() uint64 {
+	for _, name := range cgroupMemoryFiles {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
+
+// memoryGuard stops taking new batches while the memory we hold is above runtime.memory.high,
+// until it is back below runtime.memory.low, so the batches in flight can finish instead of
+// the whole process running out of memory. in a container both are kept under its limit
+type memoryGuard struct {
+	high, low uint64
+	paused    int32
+}
+
+func newMemoryGuard(highMB, lowMB int, limit uint64) *memoryGuard {
+	if lowMB <= 0 || lowMB > highMB {
+		lowMB = highMB
+	}
+	m := &memoryGuard{high: uint64(highMB) << 20, low: uint64(lowMB) << 20}
+	// the kernel kills the process at the cgroup limit, so leave 10% of it for what the go
+	// runtime doesn't account for, the low watermark shrinks by as much as the high one
+	if ceiling := limit / 10 * 9; limit > 0 && m.high > ceiling {
+		m.low -= min(m.low, m.high-ceiling)
+		m.high = ceiling
+	}
+	return m
+}
+
+// exceeded reports whether the new batches are to be turned away
+func (m *memoryGuard) exceeded() bool {
+	return m != nil && atomic.LoadInt32(&m.paused) == 1
+}
+
+// check reads the memory we hold from the os point of view, the memory given back to it excluded
+func (m *memoryGuard) check() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	inUse := ms.Sys - ms.HeapReleased
+	switch {
+	case inUse > m.high:
+		atomic.StoreInt32(&m.paused, 1)
+	case inUse < m.low:
+		atomic.StoreInt32(&m.paused, 0)
+	}
+	metricMemoryPaused.Set(int64(atomic.LoadInt32(&m.paused)))
+}
+
+func (m *memoryGuard) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for _ = range ticker.C {
+		m.check()
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupMemoryLimit(t *testing.T) {
+	tests := []struct {
+		v2, v1 string
+		want   uint64
+	}{
+		{"", "", 0},
+		{"max\n", "", 0},
+		{"536870912\n", "", 512 << 20},
+		{"", "268435456\n", 256 << 20},
+		{"", "9223372036854771712\n", 0},
+		{"536870912\n", "268435456\n", 512 << 20},
+	}
+	saved := cgroupMemoryFiles
+	defer func() { cgroupMemoryFiles = saved }()
+	for _, tt := range tests {
+		dir := t.TempDir()
+		cgroupMemoryFiles = []string{filepath.Join(dir, "memory.max"), filepath.Join(dir, "memory.limit_in_bytes")}
+		for i, content := range []string{tt.v2, tt.v1} {
+			if content != "" {
+				os.WriteFile(cgroupMemoryFiles[i], []byte(content), 0644)
+			}
+		}
+		if got := cgroupMemoryLimit(); got != tt.want {
+			t.Errorf("cgroupMemoryLimit(%q, %q) = %d, want %d", tt.v2, tt.v1, got, tt.want)
+		}
+	}
+}
+
+func TestNewMemoryGuard(t *testing.T) {
+	tests := []struct {
+		high, low int
+		limit     uint64
+		wantHigh  uint64
+		wantLow   uint64
+	}{
+		{1000, 800, 0, 1000 << 20, 800 << 20},
+		{1000, 0, 0, 1000 << 20, 1000 << 20},
+		{1000, 800, 2000 << 20, 1000 << 20, 800 << 20},
+		{1000, 800, 1000 << 20, 900 << 20, 700 << 20},
+		{1000, 100, 200 << 20, 180 << 20, 0},
+	}
+	for _, tt := range tests {
+		m := newMemoryGuard(tt.high, tt.low, tt.limit)
+		if m.high != tt.wantHigh || m.low != tt.wantLow {
+			t.Errorf("newMemoryGuard(%d, %d, %d) = %d/%d, want %d/%d", tt.high, tt.low, tt.limit, m.high, m.low, tt.wantHigh, tt.wantLow)
+		}
+	}
+}
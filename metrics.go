@@ -0,0 +1,121 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// domainMetrics counts the validated emails per domain and deliverability, as in
+// results.domains: {"gmail.com": {"deliverable": 10}}. only the domains of metrics.domains get their
+// own label, the rest fold into "other", so a batch of rare domains can't blow up the metrics
+type domainMetrics struct {
+	sync.Mutex
+	domains *domainsList
+	labels  map[string]*expvar.Map
+	counts  *expvar.Map
+}
+
+func newDomainMetrics(domains string) *domainMetrics {
+	m := &domainMetrics{
+		domains: newDomainsList(""),
+		labels:  make(map[string]*expvar.Map),
+		counts:  expvar.NewMap("results.domains"),
+	}
+	m.domains.addCSV(domains)
+	return m
+}
+
+// count adds the result of an email of the domain
+func (m *domainMetrics) count(domainName, deliverability string) {
+	if m == nil {
+		return
+	}
+	if !m.domains.has(domainName) {
+		domainName = "other"
+	}
+	m.Lock()
+	label, ok := m.labels[domainName]
+	if !ok {
+		label = new(expvar.Map).Init()
+		m.labels[domainName] = label
+		m.counts.Set(domainName, label)
+	}
+	m.Unlock()
+	if len(deliverability) == 0 {
+		deliverability = "unknown"
+	}
+	label.Add(deliverability, 1)
+}
+
+// cacheWindow keeps the hit rate of a cache over a sliding window of intervals, exposed
+// next to its fill ratio as the cache.<name>.hitrate and cache.<name>.fill gauges.
+// the cumulative totals hide a recent drop, like after a gc run emptied the cache
+type cacheWindow struct {
+	sync.Mutex
+	hits, misses int64
+	buckets      [][2]int64
+	next         int
+	fill         func() float64
+	hitRate      *expvar.Float
+	fillRatio    *expvar.Float
+}
+
+func newCacheWindow(name string, buckets int, fill func() float64) *cacheWindow {
+	if buckets < 1 {
+		buckets = 1
+	}
+	return &cacheWindow{
+		buckets:   make([][2]int64, buckets),
+		fill:      fill,
+		hitRate:   expvar.NewFloat("cache." + name + ".hitrate"),
+		fillRatio: expvar.NewFloat("cache." + name + ".fill"),
+	}
+}
+
+func (w *cacheWindow) hit() {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.hits++
+	w.Unlock()
+}
+
+func (w *cacheWindow) miss() {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	w.misses++
+	w.Unlock()
+}
+
+// snapshot closes the current interval, dropping the oldest one out of the window,
+// and refreshes the gauges. a window without lookups has a zero hit rate
+func (w *cacheWindow) snapshot() {
+	w.Lock()
+	w.buckets[w.next] = [2]int64{w.hits, w.misses}
+	w.next = (w.next + 1) % len(w.buckets)
+	w.hits, w.misses = 0, 0
+	var hits, total int64
+	for _, b := range w.buckets {
+		hits += b[0]
+		total += b[0] + b[1]
+	}
+	w.Unlock()
+
+	rate := 0.0
+	if total > 0 {
+		rate = float64(hits) / float64(total)
+	}
+	w.hitRate.Set(rate)
+	w.fillRatio.Set(w.fill())
+}
+
+func (w *cacheWindow) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for _ = range ticker.C {
+		w.snapshot()
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"expvar"
+	"testing"
+)
+
+// only the domains asked for get a label, however many others come first
+func TestDomainMetricsCount(t *testing.T) {
+	m := &domainMetrics{domains: newDomainsList(""), labels: make(map[string]*expvar.Map), counts: new(expvar.Map).Init()}
+	m.domains.addCSV("gmail.com,outlook.com")
+
+	for _, d := range []string{"a.example", "b.example", "c.example", "gmail.com", "d.example", "gmail.com", "outlook.com"} {
+		m.count(d, "deliverable")
+	}
+	m.count("gmail.com", "")
+
+	tests := []struct {
+		label          string
+		deliverability string
+		want           string
+	}{
+		{"gmail.com", "deliverable", "2"},
+		{"gmail.com", "unknown", "1"},
+		{"outlook.com", "deliverable", "1"},
+		{"other", "deliverable", "4"},
+	}
+	for _, tt := range tests {
+		label, _ := m.counts.Get(tt.label).(*expvar.Map)
+		if label == nil {
+			t.Errorf("no %s label", tt.label)
+			continue
+		}
+		if got := label.Get(tt.deliverability); got == nil || got.String() != tt.want {
+			t.Errorf("%s %s = %v, want %s", tt.label, tt.deliverability, got, tt.want)
+		}
+	}
+	if len(m.labels) != 3 {
+		t.Errorf("%d labels, want gmail.com, outlook.com and other", len(m.labels))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestOptions are the per request settings, taken from the query string
+// and carried along with the request context down to the workers
+type requestOptions struct {
+	// maxAge makes the cached verdicts older than it count as misses, 0 accepts any age
+	maxAge time.Duration
+	// clientIP is the address the request came from, for the audit log
+	clientIP string
+	// locale of the reason messages, reason.locale if empty
+	locale string
+	// noCache skips the cached verdicts, the fresh ones are still cached
+	noCache bool
+	// profile replaces the global timeouts, retries and probes settings, nil keeps them
+	profile *profile
+	// maxResults truncates the results of the response, 0 returns them all
+	maxResults int
+	// changedOnly returns only the emails whose verdict changed since the cached one
+	changedOnly bool
+	// scope holds the caches of the request, nil uses the global ones
+	scope *cacheScope
+	// transcript attaches the smtp conversation to the failed validations
+	transcript bool
+}
+
+type requestOptionsKey struct{}
+
+// parseRequestOptions reads the options from the query string of the request
+func parseRequestOptions(r *http.Request) (*requestOptions, error) {
+	opts := &requestOptions{clientIP: clientIP(r), locale: requestedLocale(r), noCache: noCacheRequested(r), maxResults: config.RequestMaxResults, changedOnly: config.RequestChangedOnly}
+	q := r.URL.Query()
+
+	if v := q.Get("maxAge"); len(v) > 0 {
+		d, err := parseMaxAge(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.maxAge = d
+	}
+
+	if v := q.Get("maxresults"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid maxresults: %q", v)
+		}
+		opts.maxResults = n
+	}
+
+	scope := config.RequestCacheScope
+	if v := q.Get("cachescope"); len(v) > 0 {
+		scope = v
+	}
+	switch scope {
+	case "global":
+	case "request":
+		opts.scope = newCacheScope()
+	default:
+		return nil, fmt.Errorf("invalid cachescope: %q, use global or request", scope)
+	}
+
+	opts.transcript = q.Get("transcript") == "1" && config.SMTPTranscriptMaxSize > 0
+
+	if v := q.Get("changed"); len(v) > 0 {
+		opts.changedOnly = v == "1"
+	}
+	if opts.changedOnly && !config.EmailsCacheEnabled {
+		return nil, fmt.Errorf("changed needs the emails cache enabled")
+	}
+	if opts.changedOnly && opts.scope != nil {
+		return nil, fmt.Errorf("changed needs the global caches, not cachescope=request")
+	}
+
+	if v := q.Get("profile"); len(v) > 0 {
+		p, ok := profiles[v]
+		if !ok {
+			return nil, fmt.Errorf("invalid profile: %q, use fast, balanced or thorough", v)
+		}
+		opts.profile = p
+	}
+	return opts, nil
+}
+
+// parseMaxAge accepts a duration, like 90m or 24h, or a plain number of seconds
+func parseMaxAge(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Second * time.Duration(secs), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid maxAge: %q", v)
+	}
+	return d, nil
+}
+
+// noCacheRequested reports whether the client asked for fresh verdicts,
+// with Cache-Control: no-cache or ?nocache=1
+func noCacheRequested(r *http.Request) bool {
+	if r.URL.Query().Get("nocache") == "1" {
+		return true
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func withRequestOptions(ctx context.Context, opts *requestOptions) context.Context {
+	return context.WithValue(ctx, requestOptionsKey{}, opts)
+}
+
+// optionsFrom returns the options of the request the context belongs to, the defaults if none
+func optionsFrom(ctx context.Context) *requestOptions {
+	if opts, ok := ctx.Value(requestOptionsKey{}).(*requestOptions); ok {
+		return opts
+	}
+	return &requestOptions{}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// domainOverride holds the settings used instead of the global ones when probing a given domain,
+// the zero value of each setting keeps the global one
+type domainOverride struct {
+	// Timeout is the connect timeout in seconds
+	Timeout int `json:"timeout"`
+	// HELO is the name sent with EHLO, the domain of the email by default
+	HELO string `json:"helo"`
+	// TLS set to off never uses STARTTLS, even when the mx host offers it, implicit
+	// does the tls handshake right on connect, as on port 465, and never uses STARTTLS
+	TLS  string `json:"tls"`
+	Port int    `json:"port"`
+}
+
+// domainOverrideFor returns the override of the domain, looking for an exact key first and then
+// for a wildcard one, the most specific first: *.mail.example.com before *.example.com
+func domainOverrideFor(domainName string) *domainOverride {
+	if ov, ok := config.DomainsOverrides[domainName]; ok {
+		return ov
+	}
+	for d := domainName; strings.Contains(d, "."); {
+		d = d[strings.Index(d, ".")+1:]
+		if ov, ok := config.DomainsOverrides["*."+d]; ok {
+			return ov
+		}
+	}
+	return &domainOverride{}
+}
+
+func (ov *domainOverride) timeout() time.Duration {
+	if ov.Timeout > 0 {
+		return time.Second * time.Duration(ov.Timeout)
+	}
+	return time.Second * time.Duration(config.DomainsMXQueryTimeout)
+}
+
+func (ov *domainOverride) port() string {
+	if ov.Port > 0 {
+		return strconv.Itoa(ov.Port)
+	}
+	if ov.TLS == "implicit" {
+		return "465"
+	}
+	if config.SMTPProtocol == "lmtp" {
+		return "24"
+	}
+	return "25"
+}
+
+func (ov *domainOverride) helo(domainName string) string {
+	if len(ov.HELO) > 0 {
+		return ov.HELO
+	}
+	return domainName
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// pacedConn spaces out the smtp commands by at least delay, some servers penalize the clients
+// firing their commands one right after the other. the first command waits for the delay too,
+// counted from the moment the connection was opened
+type pacedConn struct {
+	net.Conn
+	delay time.Duration
+	last  time.Time
+}
+
+func newPacedConn(conn net.Conn, delay time.Duration) *pacedConn {
+	return &pacedConn{Conn: conn, delay: delay, last: time.Now()}
+}
+
+func (p *pacedConn) Write(b []byte) (int, error) {
+	if wait := p.delay - time.Since(p.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	n, err := p.Conn.Write(b)
+	p.last = time.Now()
+	return n, err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// hashEmail returns the salted sha256 of the email, the same email always gets the same hash
+// for a given privacy.salt, so the records can still be matched without holding the address
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(config.PrivacySalt + email))
+	return hex.EncodeToString(sum[:])
+}
+
+// logEmail is the email as written to the logs, its hash with privacy.hashemails
+func logEmail(email string) string {
+	if config.PrivacyHashEmails {
+		return hashEmail(email)
+	}
+	return email
+}
+
+// logRedact replaces the email with its hash in a text about to be logged, like a smtp
+// response quoting the recipient, when privacy.hashemails is set
+func logRedact(text, email string) string {
+	if config.PrivacyHashEmails && len(email) > 0 {
+		return strings.Replace(text, email, hashEmail(email), -1)
+	}
+	return text
+}
+
+// unsaltedHashing returns the setting hashing the emails while privacy.salt is empty, if any.
+// the plain sha256 of an email is found back by hashing a list of known addresses
+func (c *configuration) unsaltedHashing() string {
+	if len(c.PrivacySalt) > 0 {
+		return ""
+	}
+	if c.PrivacyHashEmails {
+		return "privacy.hashemails"
+	}
+	if c.AuditHashEmails {
+		return "audit.hashemails"
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestUnsaltedHashing(t *testing.T) {
+	tests := []struct {
+		salt    string
+		privacy bool
+		audit   bool
+		want    string
+	}{
+		{"", false, false, ""},
+		{"", true, false, "privacy.hashemails"},
+		{"", false, true, "audit.hashemails"},
+		{"", true, true, "privacy.hashemails"},
+		{"s3cr3t", true, false, ""},
+		{"s3cr3t", false, true, ""},
+		{"s3cr3t", false, false, ""},
+	}
+	for _, tt := range tests {
+		c := newConfiguration()
+		c.PrivacySalt, c.PrivacyHashEmails, c.AuditHashEmails = tt.salt, tt.privacy, tt.audit
+		if got := c.unsaltedHashing(); got != tt.want {
+			t.Errorf("unsaltedHashing() salt %q privacy %v audit %v = %q, want %q", tt.salt, tt.privacy, tt.audit, got, tt.want)
+		}
+	}
+}
+
+func TestLogRedact(t *testing.T) {
+	defer func(hash bool, salt string) {
+		config.PrivacyHashEmails, config.PrivacySalt = hash, salt
+	}(config.PrivacyHashEmails, config.PrivacySalt)
+	config.PrivacySalt = "salt"
+
+	tests := []struct {
+		hash  bool
+		text  string
+		email string
+		want  string
+	}{
+		{false, "550 a@example.com unknown", "a@example.com", "550 a@example.com unknown"},
+		{true, "550 a@example.com unknown", "a@example.com", "550 " + hashEmail("a@example.com") + " unknown"},
+		{true, "550 no such user", "a@example.com", "550 no such user"},
+		{true, "550 no such user", "", "550 no such user"},
+	}
+	for _, tt := range tests {
+		config.PrivacyHashEmails = tt.hash
+		if got := logRedact(tt.text, tt.email); got != tt.want {
+			t.Errorf("logRedact(%q, %q) hash %v = %q, want %q", tt.text, tt.email, tt.hash, got, tt.want)
+		}
+	}
+}
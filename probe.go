@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/julienschmidt/httprouter"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeReport tells how far the conversation with a mx host got and how long each step took,
+// so the network and tls issues can be told apart from the recipient ones
+type probeReport struct {
+	Host        string   `json:"host"`
+	IP          string   `json:"ip,omitempty"`
+	Banner      string   `json:"banner,omitempty"`
+	Extensions  []string `json:"extensions,omitempty"`
+	TLSVersion  string   `json:"tlsVersion,omitempty"`
+	ConnectTime string   `json:"connectTime,omitempty"`
+	EHLOTime    string   `json:"ehloTime,omitempty"`
+	TLSTime     string   `json:"tlsTime,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// probeHandler connects to the mx host of ?host=mx.example.com:25, port 25 unless given, and goes
+// through the greeting, EHLO and STARTTLS when offered, no email is involved. the failures are
+// part of the report, the error responses are only for the bad requests. it is only served with
+// a password set and to the ports of probe.ports, and the private addresses are refused on dial
+// like for any mx host, otherwise it would be a way to scan our own network
+func probeHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if config.Verbose {
+		fmt.Println("Incoming request from:", r.RemoteAddr)
+	}
+
+	if len(config.Password) == 0 || r.Header.Get("Authorization") != config.Password {
+		sendHTTPJSONError(w, "AUTH_FAILED", "Invalid password", nil)
+		return
+	}
+
+	if memGuard.exceeded() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		sendHTTPJSONError(w, "SERVER_BUSY", "Server is low on memory, try again later", nil)
+		return
+	}
+
+	host := r.URL.Query().Get("host")
+	ov := &domainOverride{Port: 25}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			sendHTTPJSONError(w, "BAD_HOST", "Invalid port", nil)
+			return
+		}
+		host, ov.Port = h, port
+	}
+	if !probePortAllowed(ov.Port) {
+		sendHTTPJSONError(w, "BAD_HOST", "Invalid port", nil)
+		return
+	}
+	if ip := net.ParseIP(host); ip != nil && !config.SMTPAllowPrivate && isPrivateIP(ip) {
+		sendHTTPJSONError(w, "BAD_HOST", "Invalid mx host", nil)
+		return
+	}
+	if !valid.IsDNSName(host) && net.ParseIP(host) == nil {
+		sendHTTPJSONError(w, "BAD_HOST", "Invalid mx host", nil)
+		return
+	}
+
+	json.NewEncoder(w).Encode(probeMX(r.Context(), host, ov))
+}
+
+// probePortAllowed reports whether the port is one of probe.ports
+func probePortAllowed(port int) bool {
+	for _, p := range strings.Split(config.ProbePorts, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(p)); err == nil && n == port {
+			return true
+		}
+	}
+	return false
+}
+
+func probeMX(ctx context.Context, host string, ov *domainOverride) *probeReport {
+	report := &probeReport{Host: mxAddr(host, ov)}
+	ctx, cancel := context.WithTimeout(ctx, ov.timeout())
+	defer cancel()
+
+	start := time.Now()
+	c, err := smtpConnect(ctx, host, ov, connAuxiliary)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer c.close()
+	report.ConnectTime = time.Since(start).String()
+	report.IP = c.ip
+	report.Banner = c.banner
+
+	start = time.Now()
+	if err = c.Hello(ov.helo(emailDomain(config.CheckEmailFrom))); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	// the smtp client sends EHLO along with its first command
+	starttls, _ := c.Extension("STARTTLS")
+	report.EHLOTime = time.Since(start).String()
+	// the extensions offered in the clear, the ones offered over tls are only known to the smtp client
+	report.Extensions = c.wire.extensions()
+
+	if starttls {
+		start = time.Now()
+		// the same version check as the validations, so both tell a too old tls alike
+		err = c.StartTLS(tlsClientConfig(host))
+		state, ok := c.TLSConnectionState()
+		if ok {
+			report.TLSVersion = tls.VersionName(state.Version)
+		}
+		if err = tlsVersionError(state.Version, err); err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		report.TLSTime = time.Since(start).String()
+	}
+	return report
+}
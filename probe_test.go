@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProbePortAllowed(t *testing.T) {
+	tests := []struct {
+		ports string
+		port  int
+		want  bool
+	}{
+		{"25,465,587", 25, true},
+		{"25,465,587", 587, true},
+		{"25, 465 ,587", 465, true},
+		{"25,465,587", 22, false},
+		{"25,465,587", 6379, false},
+		{"", 25, false},
+	}
+	defer func(ports string) { config.ProbePorts = ports }(config.ProbePorts)
+	for _, tt := range tests {
+		config.ProbePorts = tt.ports
+		if got := probePortAllowed(tt.port); got != tt.want {
+			t.Errorf("probePortAllowed(%d) with %q = %v, want %v", tt.port, tt.ports, got, tt.want)
+		}
+	}
+}
+
+// the bad requests are answered before any connection is attempted
+func TestProbeHandlerRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		auth     string
+		host     string
+		code     string
+	}{
+		{"no password configured", "", "", "mx.example.com", "AUTH_FAILED"},
+		{"wrong password", "secret", "guess", "mx.example.com", "AUTH_FAILED"},
+		{"port not allowed", "secret", "secret", "mx.example.com:6379", "BAD_HOST"},
+		{"bad port", "secret", "secret", "mx.example.com:x", "BAD_HOST"},
+		{"loopback", "secret", "secret", "127.0.0.1:25", "BAD_HOST"},
+		{"metadata address", "secret", "secret", "169.254.169.254", "BAD_HOST"},
+		{"private", "secret", "secret", "[fd00::1]:587", "BAD_HOST"},
+		{"bad name", "secret", "secret", "mx_example..com", "BAD_HOST"},
+	}
+	defer func(password string) { config.Password = password }(config.Password)
+	for _, tt := range tests {
+		config.Password = tt.password
+		r := httptest.NewRequest("GET", "/probe?host="+tt.host, nil)
+		r.Header.Set("Authorization", tt.auth)
+		w := httptest.NewRecorder()
+		probeHandler(w, r, nil)
+
+		var body struct {
+			Code string `json:"errorCode"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body.Code != tt.code {
+			t.Errorf("%s: code %q, want %q (%s)", tt.name, body.Code, tt.code, w.Body.String())
+		}
+	}
+}
+
+// every extension is reported, not only the ones the smtp client knows of
+func TestProbeMXExtensions(t *testing.T) {
+	mx := startFakeMX(t, &fakeMX{ehlo: []string{"PIPELINING", "XCLIENT NAME ADDR", "X-EXPS GSSAPI NTLM", "SIZE 1000"}})
+	report := probeMX(context.Background(), "127.0.0.1", &domainOverride{Port: mx.port()})
+	want := []string{"PIPELINING", "XCLIENT", "X-EXPS", "SIZE"}
+	if len(report.Error) > 0 || !reflect.DeepEqual(report.Extensions, want) {
+		t.Errorf("probeMX extensions = %v, error %q, want %v", report.Extensions, report.Error, want)
+	}
+}
+
+// a too old tls fails the probe like it fails a validation
+func TestProbeMXTLSVersion(t *testing.T) {
+	saved := config.tlsMinVersion
+	defer func() { config.tlsMinVersion = saved }()
+	config.tlsMinVersion = tls.VersionTLS12
+	cert := selfSignedCert(t)
+
+	tests := []struct {
+		name      string
+		serverMax uint16
+		version   string
+		err       string
+	}{
+		{"tls 1.3", tls.VersionTLS13, "TLS 1.3", ""},
+		{"tls 1.2", tls.VersionTLS12, "TLS 1.2", ""},
+		{"tls 1.0", tls.VersionTLS10, "TLS 1.0", errTLSVersion.Error()},
+	}
+	for _, tt := range tests {
+		mx := startFakeMX(t, &fakeMX{ehlo: []string{}, tls: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS10, MaxVersion: tt.serverMax}})
+		report := probeMX(context.Background(), "127.0.0.1", &domainOverride{Port: mx.port()})
+		if report.TLSVersion != tt.version || report.Error != tt.err {
+			t.Errorf("%s: probeMX tls %q error %q, want %q %q", tt.name, report.TLSVersion, report.Error, tt.version, tt.err)
+		}
+	}
+}
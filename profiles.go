@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+)
+
+// profile is a coherent bundle of the settings trading speed for depth, so there's no need
+// to tune each timeout, retry and probe on its own
+type profile struct {
+	// Timeout is the connect timeout in seconds
+	Timeout        int
+	ConnectRetries int
+	PrimaryOnly    bool
+	CatchAll       bool
+	DeepProbe      bool
+}
+
+// profiles are the presets selectable with the profile setting or with ?profile= per request
+var profiles = map[string]*profile{
+	// fast asks the primary mx host only, once, and does no extra probe
+	"fast": {Timeout: 5, ConnectRetries: 0, PrimaryOnly: true},
+	// balanced tries all the mx hosts, retries once and detects the catch-all domains
+	"balanced": {Timeout: 10, ConnectRetries: 1, CatchAll: true},
+	// thorough waits longer, retries twice, detects the catch-all domains and goes on to DATA
+	"thorough": {Timeout: 30, ConnectRetries: 2, CatchAll: true, DeepProbe: true},
+}
+
+// apply sets the global settings of the profile
+func (p *profile) apply(c *configuration) {
+	c.DomainsMXQueryTimeout = p.Timeout
+	c.SMTPConnectRetries = p.ConnectRetries
+	c.SMTPPrimaryOnly = p.PrimaryOnly
+	c.CatchAllEnabled = p.CatchAll
+	c.SMTPDeepProbe = p.DeepProbe
+}
+
+// override returns the domain override with the timeout of the profile, unless it has its own
+func (p *profile) override(ov *domainOverride) *domainOverride {
+	if ov.Timeout > 0 || p.Timeout == config.DomainsMXQueryTimeout {
+		return ov
+	}
+	pov := *ov
+	pov.Timeout = p.Timeout
+	return &pov
+}
+
+// globalProfile is the profile made of the global settings
+func globalProfile() *profile {
+	return &profile{
+		Timeout:        config.DomainsMXQueryTimeout,
+		ConnectRetries: config.SMTPConnectRetries,
+		PrimaryOnly:    config.SMTPPrimaryOnly,
+		CatchAll:       config.CatchAllEnabled,
+		DeepProbe:      config.SMTPDeepProbe,
+	}
+}
+
+// profileFor returns the profile the request asked for, the one of the global settings otherwise
+func profileFor(ctx context.Context) *profile {
+	if p := optionsFrom(ctx).profile; p != nil {
+		return p
+	}
+	return globalProfile()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// providerRule names the mailbox provider behind the mx hosts matching the pattern
+type providerRule struct {
+	// Provider is matched against the mx host
+	Provider string `json:"provider"`
+	Name     string `json:"name"`
+
+	providerRegex *regexp.Regexp
+}
+
+// defaultProviderRules are used unless provider.rules is set in the configuration file
+var defaultProviderRules = []providerRule{
+	{Provider: `(?i)(google|googlemail)\.com$`, Name: "Gmail"},
+	{Provider: `(?i)(outlook|hotmail)\.com$`, Name: "Outlook365"},
+	{Provider: `(?i)yahoodns\.net$`, Name: "Yahoo"},
+	{Provider: `(?i)icloud\.com$`, Name: "iCloud"},
+	{Provider: `(?i)zoho\.(com|eu|in)$`, Name: "Zoho"},
+	{Provider: `(?i)protonmail\.ch$`, Name: "Proton"},
+	{Provider: `(?i)yandex\.(net|ru)$`, Name: "Yandex"},
+	{Provider: `(?i)mail\.ru$`, Name: "Mail.ru"},
+	{Provider: `(?i)(gmx|web)\.(net|de)$`, Name: "GMX"},
+	{Provider: `(?i)messagingengine\.com$`, Name: "Fastmail"},
+	{Provider: `(?i)secureserver\.net$`, Name: "GoDaddy"},
+	{Provider: `(?i)mimecast\.com$`, Name: "Mimecast"},
+	{Provider: `(?i)pphosted\.com$`, Name: "Proofpoint"},
+	{Provider: `(?i)barracudanetworks\.com$`, Name: "Barracuda"},
+}
+
+// compileProviderRule compiles the regex of the rule
+func compileProviderRule(rule *providerRule) (err error) {
+	rule.providerRegex, err = regexp.Compile(rule.Provider)
+	return err
+}
+
+// classifyProvider names the provider of the domain out of its primary mx host. mx hosts under
+// the domain itself are self-hosted, anything else not matching any rule is unknown
+func classifyProvider(domainName, mxHost string) string {
+	mxHost = strings.TrimSuffix(mxHost, ".")
+	for _, rule := range config.ProviderRules {
+		if rule.providerRegex.MatchString(mxHost) {
+			return rule.Name
+		}
+	}
+	if mxHost == domainName || strings.HasSuffix(mxHost, "."+domainName) {
+		return "self-hosted"
+	}
+	return "unknown"
+}
+
+// tagProvider sets the provider of the result out of the mx records in the mx cache, the cached
+// verdicts included, or else out of the mx host talked to. it never asks the dns itself, the
+// verdicts reached without any lookup, like the honeypot ones, only get a provider from the cache
+func tagProvider(ctx context.Context, res *emailResult, email string) {
+	domainName := emailDomain(email)
+	if mxCache := mxCacheFor(ctx); mxCache != nil {
+		if mxRecords, ok := mxCache.get(domainName); ok && len(mxRecords) > 0 && !isNullMX(mxRecords) {
+			res.Provider = classifyProvider(domainName, mxRecords[0].Host)
+			return
+		}
+	}
+	if host, _, err := net.SplitHostPort(res.MXHost); err == nil {
+		res.Provider = classifyProvider(domainName, host)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClassifyProvider(t *testing.T) {
+	tests := []struct {
+		domain string
+		mxHost string
+		want   string
+	}{
+		{"gmail.com", "gmail-smtp-in.l.google.com.", "Gmail"},
+		{"example.com", "example-com.mail.protection.outlook.com", "Outlook365"},
+		{"example.com", "mx.example.com", "self-hosted"},
+		{"example.com", "example.com", "self-hosted"},
+		{"example.com", "mx.notexample.com", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := classifyProvider(tt.domain, tt.mxHost); got != tt.want {
+			t.Errorf("classifyProvider(%q, %q) = %s, want %s", tt.domain, tt.mxHost, got, tt.want)
+		}
+	}
+}
+
+// the provider comes from the mx cache or the mx host talked to, never from a dns query
+func TestTagProvider(t *testing.T) {
+	defer func(enabled bool, c *domainsMXCache) {
+		config.DomainsMXCacheEnabled, dMXCache = enabled, c
+	}(config.DomainsMXCacheEnabled, dMXCache)
+	config.DomainsMXCacheEnabled = true
+	dMXCache = &domainsMXCache{maxSize: 10}
+	dMXCache.add("cached.example", []*net.MX{{Host: "aspmx.l.google.com.", Pref: 1}}, time.Hour)
+
+	tests := []struct {
+		email  string
+		mxHost string
+		want   string
+	}{
+		{"a@cached.example", "", "Gmail"},
+		{"a@fresh.example", "mx1.fresh.example:25", "self-hosted"},
+		{"a@honeypot.invalid", "", ""},
+	}
+	for _, tt := range tests {
+		res := &emailResult{MXHost: tt.mxHost}
+		tagProvider(context.Background(), res, tt.email)
+		if res.Provider != tt.want {
+			t.Errorf("tagProvider(%s) = %q, want %q", tt.email, res.Provider, tt.want)
+		}
+	}
+}
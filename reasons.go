@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+)
+
+// reasonCodeRule maps a smtp response to a standard reason code, so that clients
+// don't have to know how each provider words the same problem
+type reasonCodeRule struct {
+	// Provider is matched against the mx host that answered, empty matches any host
+	Provider string `json:"provider"`
+	// Pattern is matched against the raw response
+	Pattern string `json:"pattern"`
+	Code    string `json:"code"`
+
+	providerRegex *regexp.Regexp
+	patternRegex  *regexp.Regexp
+}
+
+// defaultReasonCodeRules are used unless reason.codes is set in the configuration file.
+// provider specific rules come first, the generic ones act as a fallback
+var defaultReasonCodeRules = []reasonCodeRule{
+	// gmail
+	{Provider: `(?i)(google|googlemail)\.com`, Pattern: `(?i)5\.1\.1`, Code: "MAILBOX_NOT_FOUND"},
+	{Provider: `(?i)(google|googlemail)\.com`, Pattern: `(?i)5\.2\.1`, Code: "MAILBOX_DISABLED"},
+	{Provider: `(?i)(google|googlemail)\.com`, Pattern: `(?i)[45]\.2\.2`, Code: "MAILBOX_FULL"},
+	{Provider: `(?i)(google|googlemail)\.com`, Pattern: `(?i)4\.7\.28|unusual rate`, Code: "RATE_LIMITED"},
+	// outlook / office 365
+	{Provider: `(?i)(outlook|hotmail)\.com`, Pattern: `(?i)5\.1\.10|RecipientNotFound|5\.5\.0 Requested action not taken: mailbox unavailable`, Code: "MAILBOX_NOT_FOUND"},
+	{Provider: `(?i)(outlook|hotmail)\.com`, Pattern: `(?i)5\.7\.1.*(block list|blocked)|S3150`, Code: "SENDER_BLOCKED"},
+	// yahoo / aol
+	{Provider: `(?i)yahoodns\.net`, Pattern: `(?i)user doesn't have a|5\.1\.1`, Code: "MAILBOX_NOT_FOUND"},
+	{Provider: `(?i)yahoodns\.net`, Pattern: `(?i)TSS0[0-9]|temporarily deferred`, Code: "RATE_LIMITED"},
+	// our own messages
+	{Pattern: `(?i)^OK`, Code: "OK"},
+	{Pattern: `(?i)^invalid email address \(local part too long\)`, Code: "LOCAL_TOO_LONG"},
+	{Pattern: `(?i)^invalid email address \(domain (label )?too long\)`, Code: "DOMAIN_TOO_LONG"},
+	{Pattern: `(?i)^invalid email address \(address too long\)`, Code: "ADDRESS_TOO_LONG"},
+	{Pattern: `(?i)^invalid email address`, Code: "INVALID_SYNTAX"},
+	{Pattern: `(?i)^email address is blacklisted`, Code: "BLACKLISTED"},
+	{Pattern: `(?i)^honeypot domain`, Code: "HONEYPOT"},
+	{Pattern: `(?i)^no mx record found`, Code: "NO_MX"},
+	{Pattern: `(?i)^no mx nor a record found`, Code: "NO_MAIL_HOST"},
+	{Pattern: `(?i)^domain does not accept mail`, Code: "NULL_MX"},
+	{Pattern: `(?i)^mx points to private address`, Code: "PRIVATE_MX"},
+	{Pattern: `(?i)^mx misconfigured`, Code: "MX_MISCONFIGURED"},
+	{Pattern: `(?i)^missing required smtp extensions`, Code: "MISSING_EXTENSIONS"},
+	{Pattern: `(?i)^tls version below the minimum required`, Code: "TLS_VERSION"},
+	{Pattern: `(?i)no such host`, Code: "NO_SUCH_DOMAIN"},
+	{Pattern: `(?i)^no such domain`, Code: "NO_SUCH_DOMAIN"},
+	{Pattern: `(?i)^(unknown|invalid) \(timeout\)`, Code: "TIMEOUT"},
+	{Pattern: `(?i)^unknown \(unreachable\)`, Code: "UNREACHABLE"},
+	{Pattern: `(?i)^unknown \(ipv6 unreachable`, Code: "IPV6_UNREACHABLE"},
+	{Pattern: `(?i)^unknown \(server requires auth\)`, Code: "AUTH_REQUIRED"},
+	// generic smtp responses
+	{Pattern: `(?i)5\.1\.1|user unknown|unknown user|does not exist|no such (user|mailbox)|recipient not found`, Code: "MAILBOX_NOT_FOUND"},
+	// only the mailbox being full, not the server lacking storage of its own like 452 4.3.1
+	{Pattern: `(?i)[45]\.2\.2|\b(mailbox|mail box|inbox|account|user|recipient)\b.{0,40}\b(full|over ?quota|quota exceeded|exceeded (its|the) (storage|quota))\b`, Code: "MAILBOX_FULL"},
+	{Pattern: `(?i)5\.2\.1|^55[0-9][ -].*\b(mailbox|account|user|recipient)\b.{0,40}\b(disabled|inactive)\b`, Code: "MAILBOX_DISABLED"},
+	{Pattern: `(?i)greylist`, Code: "GREYLISTED"},
+	{Pattern: `(?i)^421|too many (connections|messages)|rate limit`, Code: "RATE_LIMITED"},
+}
+
+// compileReasonCodeRule compiles the regexes of the rule
+func compileReasonCodeRule(rule *reasonCodeRule) (err error) {
+	if len(rule.Provider) > 0 {
+		if rule.providerRegex, err = regexp.Compile(rule.Provider); err != nil {
+			return err
+		}
+	}
+	rule.patternRegex, err = regexp.Compile(rule.Pattern)
+	return err
+}
+
+// reasonCode returns the code of the first rule matching the response given by the mx host
+func reasonCode(message, mxHost string) string {
+	for _, rule := range config.ReasonCodeRules {
+		if rule.providerRegex != nil && !rule.providerRegex.MatchString(mxHost) {
+			continue
+		}
+		if rule.patternRegex.MatchString(message) {
+			return rule.Code
+		}
+	}
+	return "UNKNOWN"
+}
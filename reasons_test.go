@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReasonCode(t *testing.T) {
+	tests := []struct {
+		message string
+		mxHost  string
+		want    string
+	}{
+		{"OK", "", "OK"},
+		{"550 5.1.1 The email account that you tried to reach does not exist", "gmail-smtp-in.l.google.com:25", "MAILBOX_NOT_FOUND"},
+		{"550 5.2.1 The email account that you tried to reach is disabled", "gmail-smtp-in.l.google.com:25", "MAILBOX_DISABLED"},
+		{"550 mailbox disabled for this recipient", "mx.example.com:25", "MAILBOX_DISABLED"},
+		{"550 5.7.1 user account is inactive", "mx.example.com:25", "MAILBOX_DISABLED"},
+		{"451 4.3.0 temporarily disabled, try again", "mx.example.com:25", "UNKNOWN"},
+		{"554 5.7.1 relaying disabled", "mx.example.com:25", "UNKNOWN"},
+		{"250 inactive sessions closed", "mx.example.com:25", "UNKNOWN"},
+		{"451 4.7.1 greylisted, try again later", "mx.example.com:25", "GREYLISTED"},
+		{"452 4.2.2 The email account that you tried to reach is over quota", "gmail-smtp-in.l.google.com:25", "MAILBOX_FULL"},
+		{"552 5.2.2 mailbox full", "mx.example.com:25", "MAILBOX_FULL"},
+		{"552 Requested mail action aborted: mailbox is full", "mx.example.com:25", "MAILBOX_FULL"},
+		{"452 user over quota", "mx.example.com:25", "MAILBOX_FULL"},
+		{"550 recipient has exceeded the storage quota", "mx.example.com:25", "MAILBOX_FULL"},
+		{"452 4.3.1 insufficient system storage", "mx.example.com:25", "UNKNOWN"},
+		{"452 4.5.3 too many recipients, storage", "mx.example.com:25", "UNKNOWN"},
+		{"550 5.7.1 daily sending quota exceeded", "mx.example.com:25", "UNKNOWN"},
+		{"452 disk full, try later", "mx.example.com:25", "UNKNOWN"},
+		{"invalid email address", "", "INVALID_SYNTAX"},
+		{"invalid email address (local part too long)", "", "LOCAL_TOO_LONG"},
+		{"honeypot domain", "", "HONEYPOT"},
+	}
+	for _, tt := range tests {
+		if got := reasonCode(tt.message, tt.mxHost); got != tt.want {
+			t.Errorf("reasonCode(%q, %q) = %s, want %s", tt.message, tt.mxHost, got, tt.want)
+		}
+	}
+}
+
+// a cache hit gets the code the verdict had, the provider rules matched the mx host then
+func TestCachedVerdictCode(t *testing.T) {
+	tests := []struct {
+		message string
+		code    string
+		want    string
+	}{
+		{"550 5.2.2 The email account that you tried to reach is over quota", "MAILBOX_FULL", "MAILBOX_FULL"},
+		{"550 5.1.10 RESOLVER.ADR.RecipientNotFound", "MAILBOX_NOT_FOUND", "MAILBOX_NOT_FOUND"},
+		{"550 user unknown", "", "MAILBOX_NOT_FOUND"},
+	}
+	for _, tt := range tests {
+		res := &emailResult{}
+		cachedVerdict(res, "a@example.com", emailsCacheDataItem{key: "a@example.com", val: tt.message, code: tt.code, cachedAt: time.Now()})
+		if res.ReasonCode != tt.want {
+			t.Errorf("cachedVerdict(%q, %q) code = %s, want %s", tt.message, tt.code, res.ReasonCode, tt.want)
+		}
+	}
+}
+
+// a response no response regex matches is let slide as OK, its reason code still decides
+// whether the email can be delivered
+func TestVeResValUnmatched(t *testing.T) {
+	saved := config.emValRespRegexes
+	defer func() { config.emValRespRegexes = saved }()
+	config.emValRespRegexes = nil
+
+	tests := []struct {
+		message        string
+		code           string
+		deliverability string
+		valid          bool
+	}{
+		{"OK", "OK", "deliverable", true},
+		{"550 5.1.1 no such user", "MAILBOX_NOT_FOUND", "undeliverable", false},
+		{"550 5.2.1 mailbox disabled", "MAILBOX_DISABLED", "undeliverable", false},
+		{"552 5.2.2 mailbox full", "MAILBOX_FULL", "full", false},
+		{"554 5.7.1 relaying denied", "UNKNOWN", "deliverable", true},
+	}
+	for _, tt := range tests {
+		res := &emailResult{uncached: true}
+		if verdict := veResVal(res, "a@example.com", tt.message); verdict != "OK" {
+			t.Errorf("veResVal(%q) = %q, want it let slide as OK", tt.message, verdict)
+		}
+		if res.ReasonCode != tt.code || res.Deliverability != tt.deliverability || res.Valid != tt.valid {
+			t.Errorf("veResVal(%q) = %s %s valid %v, want %s %s valid %v", tt.message, res.ReasonCode, res.Deliverability, res.Valid, tt.code, tt.deliverability, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryTimeout bounds a retry, email.timeout bounds it further when set, so a stalled mx host
+// can't hold up the next ticks
+const retryTimeout = time.Minute
+
+// retryItem is a greylisted email waiting to be validated again. running is set while it is
+// being retried, it stays in the queue and in its file until the retry is over
+type retryItem struct {
+	email    string
+	due      time.Time
+	attempts int
+	running  bool
+}
+
+// retryQueue holds the greylisted emails until the greylisting period is over and validates them
+// again then, so the cache gets their real verdict. the queue is written to its file on every change,
+// one "due time<TAB>attempts<TAB>email" line per email, so the pending retries survive a restart
+type retryQueue struct {
+	sync.Mutex
+	file   string
+	delay  time.Duration
+	max    int
+	items  map[string]*retryItem
+	saveMu sync.Mutex
+}
+
+func newRetryQueue(file string, delay time.Duration, max int) (*retryQueue, error) {
+	q := &retryQueue{file: file, delay: delay, max: max, items: make(map[string]*retryItem)}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// add queues the email for another validation after the delay, unless it is queued already
+// or it was tried too many times
+func (q *retryQueue) add(email string, attempts int) {
+	if q == nil || attempts >= q.max {
+		return
+	}
+	q.Lock()
+	if _, ok := q.items[email]; ok {
+		q.Unlock()
+		return
+	}
+	q.items[email] = &retryItem{email: email, due: time.Now().Add(q.delay), attempts: attempts}
+	q.Unlock()
+	q.persist()
+}
+
+// takeDue returns the emails whose time has come, marked as running, they are removed from
+// the queue by done once retried, so a crash in between does not lose them
+func (q *retryQueue) takeDue() []*retryItem {
+	now := time.Now()
+	var due []*retryItem
+	q.Lock()
+	for _, item := range q.items {
+		if !item.running && !now.Before(item.due) {
+			item.running = true
+			due = append(due, item)
+		}
+	}
+	q.Unlock()
+	return due
+}
+
+// done removes the retried email from the queue, or queues it once more when still greylisted,
+// until retry.max attempts
+func (q *retryQueue) done(item *retryItem, greylisted bool) {
+	q.Lock()
+	if greylisted && item.attempts+1 < q.max {
+		item.attempts++
+		item.due = time.Now().Add(q.delay)
+		item.running = false
+	} else {
+		delete(q.items, item.email)
+	}
+	q.Unlock()
+	q.persist()
+}
+
+// run validates the due emails, at most concurrency at a time. the retries have their own
+// goroutines, so they never take workers away from the requests, and a tick waits for
+// the retries of the previous one
+func (q *retryQueue) run(interval time.Duration, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	ticker := time.NewTicker(interval)
+	for _ = range ticker.C {
+		var wg sync.WaitGroup
+		for _, item := range q.takeDue() {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(item *retryItem) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				q.retry(item)
+			}(item)
+		}
+		wg.Wait()
+	}
+}
+
+// retry validates the email again, one still greylisted stays in the queue, until retry.max attempts
+func (q *retryQueue) retry(item *retryItem) {
+	if config.EmailsCacheEnabled {
+		eCache.remove(item.email)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), retryTimeout)
+	defer cancel()
+	res := &emailResult{}
+	res.Message = validateWithin(ctx, item.email, res)
+	if config.Verbose {
+		fmt.Println("Retried greylisted", logEmail(item.email), "and got:", logRedact(res.Message, item.email))
+	}
+	q.done(item, res.ReasonCode == "GREYLISTED")
+	if config.EventsEnabled {
+		eventsPub.publish(item.email, res)
+	}
+	if auditLogger != nil {
+		auditLogger.record(context.Background(), item.email, res)
+	}
+}
+
+func (q *retryQueue) persist() {
+	if err := q.save(); err != nil {
+		fmt.Println("Retry queue file write error:", err)
+	}
+}
+
+// load reads the queue back from its file, a missing file is an empty queue
+func (q *retryQueue) load() error {
+	if len(q.file) == 0 {
+		return nil
+	}
+	f, err := os.Open(q.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	q.Lock()
+	defer q.Unlock()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		due, err := time.Parse(time.RFC3339, parts[0])
+		if err != nil {
+			continue
+		}
+		attempts, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		q.items[parts[2]] = &retryItem{email: parts[2], due: due, attempts: attempts}
+	}
+	return scanner.Err()
+}
+
+// save writes the queue to its file, replacing it atomically
+func (q *retryQueue) save() error {
+	if len(q.file) == 0 {
+		return nil
+	}
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	// copies, done changes the items of the other retries meanwhile
+	q.Lock()
+	items := make([]retryItem, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+	q.Unlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].due.Before(items[j].due)
+	})
+
+	tmp, err := ioutil.TempFile(filepath.Dir(q.file), filepath.Base(q.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", item.due.UTC().Format(time.RFC3339), item.attempts, item.email)
+	}
+	if err = w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.file)
+}
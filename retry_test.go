@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryQueueDone(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempts   int
+		greylisted bool
+		queued     bool
+	}{
+		{"delivered", 0, false, false},
+		{"still greylisted", 0, true, true},
+		{"greylisted, last attempt", 2, true, false},
+	}
+	for _, tt := range tests {
+		q, err := newRetryQueue(filepath.Join(t.TempDir(), "retry.tsv"), -time.Second, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.add("a@example.com", tt.attempts)
+		due := q.takeDue()
+		if len(due) != 1 {
+			t.Fatalf("%s: takeDue returned %d items, want 1", tt.name, len(due))
+		}
+		if len(q.takeDue()) != 0 {
+			t.Errorf("%s: a running item was taken again", tt.name)
+		}
+		q.done(due[0], tt.greylisted)
+
+		// a fresh queue reads what is left from the file
+		reloaded, err := newRetryQueue(q.file, q.delay, q.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, ok := reloaded.items["a@example.com"]
+		if ok != tt.queued {
+			t.Errorf("%s: queued %v, want %v", tt.name, ok, tt.queued)
+		}
+		if ok && item.attempts != tt.attempts+1 {
+			t.Errorf("%s: attempts %d, want %d", tt.name, item.attempts, tt.attempts+1)
+		}
+	}
+}
+
+// the due items stay in the file until retried, a restart in between retries them again
+func TestRetryQueueSurvivesCrash(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "retry.tsv")
+	q, err := newRetryQueue(file, -time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{"a@example.com", "b@example.com"} {
+		q.add(e, 0)
+	}
+	if due := q.takeDue(); len(due) != 2 {
+		t.Fatalf("takeDue returned %d items, want 2", len(due))
+	}
+
+	restarted, err := newRetryQueue(file, -time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := restarted.takeDue(); len(due) != 2 {
+		t.Errorf("after a restart takeDue returned %d items, want 2", len(due))
+	}
+}
+
+// the retries of retry.concurrency > 1 finish at the same time, each one saving the queue
+func TestRetryQueueConcurrentDone(t *testing.T) {
+	tests := []struct {
+		emails     int
+		greylisted bool
+		queued     int
+	}{
+		{20, true, 20},
+		{20, false, 0},
+	}
+	for _, tt := range tests {
+		q, err := newRetryQueue(filepath.Join(t.TempDir(), "retry.tsv"), -time.Second, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < tt.emails; i++ {
+			q.add(fmt.Sprintf("%d@example.com", i), 0)
+		}
+		var wg sync.WaitGroup
+		for _, item := range q.takeDue() {
+			wg.Add(1)
+			go func(item *retryItem) {
+				defer wg.Done()
+				q.done(item, tt.greylisted)
+			}(item)
+		}
+		wg.Wait()
+
+		reloaded, err := newRetryQueue(q.file, q.delay, q.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reloaded.items) != tt.queued {
+			t.Errorf("greylisted %v: %d emails saved, want %d", tt.greylisted, len(reloaded.items), tt.queued)
+		}
+		for e, item := range reloaded.items {
+			if item.attempts != 1 {
+				t.Errorf("greylisted %v: %s saved with %d attempts, want 1", tt.greylisted, e, item.attempts)
+			}
+		}
+	}
+}
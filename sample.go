@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+)
+
+// sampleEmails picks, for each domain, the fraction of its emails that will really be probed.
+// the picks are spread evenly over the emails of the domain, the rest is returned by domain.
+// a fraction above 1 probes them all, at least one is probed however small it is
+func sampleEmails(emails []string, fraction float64) ([]string, map[string][]string) {
+	byDomain := make(map[string][]string)
+	var domains []string
+	for _, e := range emails {
+		d := emailDomain(e)
+		if _, ok := byDomain[d]; !ok {
+			domains = append(domains, d)
+		}
+		byDomain[d] = append(byDomain[d], e)
+	}
+
+	var sampled []string
+	rest := make(map[string][]string)
+	for _, d := range domains {
+		des := byDomain[d]
+		n := int(math.Ceil(float64(len(des)) * fraction))
+		n = max(1, min(n, len(des)))
+		// the k-th pick is at k*len/n, in integers so no rounding drift skips one
+		k := 0
+		for i, e := range des {
+			if k < n && i == k*len(des)/n {
+				sampled = append(sampled, e)
+				k++
+				continue
+			}
+			rest[d] = append(rest[d], e)
+		}
+	}
+	return sampled, rest
+}
+
+// estimateEmails gives the emails left out of the sample the most common verdict
+// the sampled emails of their domain got, flagged as estimated
+func estimateEmails(o *outgoingEmails, rest map[string][]string) {
+	o.Lock()
+	byDomain := make(map[string][]*emailResult)
+	for e, res := range o.Results {
+		d := emailDomain(e)
+		if _, ok := rest[d]; ok {
+			byDomain[d] = append(byDomain[d], res)
+		}
+	}
+	o.Unlock()
+
+	for d, emails := range rest {
+		counts := make(map[string]int)
+		var best *emailResult
+		for _, res := range byDomain[d] {
+			counts[res.ReasonCode]++
+			if best == nil || counts[res.ReasonCode] > counts[best.ReasonCode] {
+				best = res
+			}
+		}
+		if best == nil {
+			continue
+		}
+		for _, e := range emails {
+			o.Add(e, &emailResult{
+				Message:        best.Message,
+				MXHost:         best.MXHost,
+				MXCount:        best.MXCount,
+				ReasonCode:     best.ReasonCode,
+				Reason:         best.Reason,
+				Domain:         best.Domain,
+				Provider:       best.Provider,
+				MailboxFull:    best.MailboxFull,
+				Deliverability: best.Deliverability,
+				Valid:          best.Valid,
+				Freemail:       best.Freemail,
+				Estimated:      true,
+			})
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSampleEmails(t *testing.T) {
+	tests := []struct {
+		emails   []string
+		fraction float64
+		sampled  []string
+		rest     map[string][]string
+	}{
+		{
+			[]string{"a@x.com", "b@x.com", "c@x.com", "d@x.com"}, 0.5,
+			[]string{"a@x.com", "c@x.com"},
+			map[string][]string{"x.com": {"b@x.com", "d@x.com"}},
+		},
+		{
+			[]string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com", "f@x.com"}, 0.3,
+			[]string{"a@x.com", "d@x.com"},
+			map[string][]string{"x.com": {"b@x.com", "c@x.com", "e@x.com", "f@x.com"}},
+		},
+		{
+			// each domain gets at least one probe, small fractions included
+			[]string{"a@x.com", "b@x.com", "a@y.com"}, 0.01,
+			[]string{"a@x.com", "a@y.com"},
+			map[string][]string{"x.com": {"b@x.com"}},
+		},
+		{
+			[]string{"a@x.com", "b@x.com", "c@x.com", "a@y.com"}, 2,
+			[]string{"a@x.com", "b@x.com", "c@x.com", "a@y.com"},
+			map[string][]string{},
+		},
+		{
+			// 7 picks out of 10, a float step of 10/7 would drift
+			[]string{"0@x.com", "1@x.com", "2@x.com", "3@x.com", "4@x.com", "5@x.com", "6@x.com", "7@x.com", "8@x.com", "9@x.com"}, 0.7,
+			[]string{"0@x.com", "1@x.com", "2@x.com", "4@x.com", "5@x.com", "7@x.com", "8@x.com"},
+			map[string][]string{"x.com": {"3@x.com", "6@x.com", "9@x.com"}},
+		},
+		{
+			[]string{"a@x.com", "a@y.com", "b@x.com"}, 1,
+			[]string{"a@x.com", "b@x.com", "a@y.com"},
+			map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		sampled, rest := sampleEmails(tt.emails, tt.fraction)
+		if !reflect.DeepEqual(sampled, tt.sampled) || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("sampleEmails(%v, %v) = %v, %v, want %v, %v", tt.emails, tt.fraction, sampled, rest, tt.sampled, tt.rest)
+		}
+	}
+}
+
+func TestEstimateEmails(t *testing.T) {
+	tests := []struct {
+		sampled map[string]string
+		rest    map[string][]string
+		want    map[string]string
+	}{
+		{
+			map[string]string{"a@x.com": "OK", "b@x.com": "OK", "c@x.com": "MAILBOX_NOT_FOUND"},
+			map[string][]string{"x.com": {"d@x.com"}},
+			map[string]string{"d@x.com": "OK"},
+		},
+		{
+			map[string]string{"a@x.com": "MAILBOX_NOT_FOUND", "a@y.com": "OK"},
+			map[string][]string{"x.com": {"b@x.com", "c@x.com"}},
+			map[string]string{"b@x.com": "MAILBOX_NOT_FOUND", "c@x.com": "MAILBOX_NOT_FOUND"},
+		},
+		{
+			// nothing of the domain was probed, nothing to estimate from
+			map[string]string{"a@y.com": "OK"},
+			map[string][]string{"x.com": {"b@x.com"}},
+			map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		o := newOutgoingEmails(len(tt.sampled))
+		for e, code := range tt.sampled {
+			o.Add(e, &emailResult{Message: code, ReasonCode: code})
+		}
+		estimateEmails(o, tt.rest)
+		for e, want := range tt.want {
+			res, ok := o.Results[e]
+			if !ok || res.ReasonCode != want || !res.Estimated || o.Emails[e] != want {
+				t.Errorf("estimateEmails(%v) of %s = %+v, want %s estimated", tt.sampled, e, res, want)
+			}
+		}
+		if got := len(o.Results) - len(tt.sampled); got != len(tt.want) {
+			t.Errorf("estimateEmails(%v) added %d results, want %d", tt.sampled, got, len(tt.want))
+		}
+	}
+}
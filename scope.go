@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// cacheScope holds the caches of a request with a cache scope of its own, ?cachescope=request: the mx
+// records, the host addresses, their reverse dns and the catch-all and subaddressing detections.
+// they live as long as the request, which neither reads nor writes the global caches, nor takes the
+// warm connections, so a change to the dns or the mail setup of a domain shows right away. the verdicts
+// of such a request are not cached at all. the domains which blacklisted our own ip stay global,
+// they are about the sender, not the domain
+type cacheScope struct {
+	mx       *domainsMXCache
+	hostIPs  *hostIPsCache
+	rdns     *reverseDNSCache
+	catchAll *catchAllDomains
+	subaddrs *subaddressDomains
+}
+
+// newCacheScope creates the caches of a request, as big as the global ones. they have no gc, they go with the request.
+// the catch-all probes still share the global concurrency limit
+func newCacheScope() *cacheScope {
+	maxSize := config.DomainsMXCacheMaxSize
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	ttl := time.Second * time.Duration(config.DNSHostsCacheTTL)
+	return &cacheScope{
+		mx:       &domainsMXCache{maxSize: maxSize},
+		hostIPs:  &hostIPsCache{ttl: ttl, data: make(map[string]hostIPsItem)},
+		rdns:     &reverseDNSCache{ttl: ttl, data: make(map[string]reverseDNS)},
+		catchAll: &catchAllDomains{data: make(map[string]bool), slots: catchAll.slots, probes: make(map[string]*catchAllProbe)},
+		subaddrs: &subaddressDomains{data: make(map[string]bool)},
+	}
+}
+
+// mxCacheFor returns the mx cache the request uses, nil when there is none
+func mxCacheFor(ctx context.Context) *domainsMXCache {
+	if s := optionsFrom(ctx).scope; s != nil {
+		return s.mx
+	}
+	if config.DomainsMXCacheEnabled {
+		return dMXCache
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// a request with its own cache scope sees only what it found itself, never the global detections
+func TestCacheScope(t *testing.T) {
+	catchAll.add("scoped.example", true)
+	defer func() {
+		catchAll.Lock()
+		delete(catchAll.data, "scoped.example")
+		catchAll.Unlock()
+	}()
+
+	scope := newCacheScope()
+	scope.catchAll.add("scoped.example", false)
+	scope.subaddrs.add("scoped.example", true)
+	scope.rdns.data["192.0.2.1"] = reverseDNS{ptr: "mx.scoped.example", confirmed: true, expiresAt: time.Now().Add(time.Hour)}
+	ctx := withRequestOptions(context.Background(), &requestOptions{scope: scope})
+
+	rdns, err := reverseDNSs.lookup(ctx, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"global catch-all", fmtBool(catchAll.detect(context.Background(), "a@scoped.example", "mx.scoped.example")), "true"},
+		{"scoped catch-all", fmtBool(catchAll.detect(ctx, "a@scoped.example", "mx.scoped.example")), "false"},
+		{"scoped subaddressing", fmtBool(subaddrs.detect(ctx, "a@scoped.example", "mx.scoped.example")), "true"},
+		{"scoped reverse dns", rdns.ptr, "mx.scoped.example"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if _, ok := catchAll.get("scoped.example"); !ok {
+		t.Error("the scoped request touched the global catch-all cache")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subaddressDomains detects and caches whether domains accept subaddresses, like local+tag@domain
+type subaddressDomains struct {
+	sync.Mutex
+	gcFrequency time.Duration
+	data        map[string]bool
+}
+
+func (d *subaddressDomains) get(k string) (bool, bool) {
+	d.Lock()
+	defer d.Unlock()
+	v, ok := d.data[k]
+	return v, ok
+}
+
+func (d *subaddressDomains) add(k string, v bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.data[k] = v
+}
+
+func (d *subaddressDomains) gcHandler() {
+	ticker := time.NewTicker(d.gcFrequency)
+	for _ = range ticker.C {
+		d.Lock()
+		d.data = make(map[string]bool)
+		d.Unlock()
+	}
+}
+
+// detect tells whether the domain accepts a random subaddress of the email, which must exist.
+// it returns nil when the detection could not be done
+func (d *subaddressDomains) detect(ctx context.Context, email, host string) *bool {
+	if scope := optionsFrom(ctx).scope; scope != nil && d != scope.subaddrs {
+		return scope.subaddrs.detect(ctx, email, host)
+	}
+	domainName := emailDomain(email)
+	if v, ok := d.get(domainName); ok {
+		return &v
+	}
+
+	local := email[:strings.LastIndex(email, "@")]
+	if strings.HasPrefix(local, `"`) {
+		return nil
+	}
+	if i := strings.Index(local, "+"); i > 0 {
+		local = local[:i]
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil
+	}
+	tagged := fmt.Sprintf("%s+evs%s@%s", local, hex.EncodeToString(b), domainName)
+
+	ov := domainOverrideFor(domainName)
+	c, err := smtpConnect(ctx, host, ov, connAuxiliary)
+	if err != nil {
+		return nil
+	}
+	defer c.close()
+
+	if err = smtpGreet(c, domainName, host, ov); err != nil {
+		return nil
+	}
+
+	accepted := c.Rcpt(tagged) == nil
+	if ctx.Err() != nil {
+		return nil
+	}
+	d.add(domainName, accepted)
+	if config.Verbose {
+		fmt.Println("Subaddressing detection for", domainName, "says:", accepted)
+	}
+	return &accepted
+}
+
+func newSubaddressDomains() *subaddressDomains {
+	d := &subaddressDomains{
+		gcFrequency: time.Second * time.Duration(config.SubaddressingGCFrequency),
+		data:        make(map[string]bool),
+	}
+	if config.SubaddressingGCFrequency > 0 {
+		go d.gcHandler()
+	}
+	return d
+}
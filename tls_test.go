@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTLSVersionError(t *testing.T) {
+	saved := config.tlsMinVersion
+	defer func() { config.tlsMinVersion = saved }()
+	config.tlsMinVersion = tls.VersionTLS12
+
+	other := errors.New("tls: bad certificate")
+	notTLS := tls.RecordHeaderError{RecordHeader: [5]byte{'2', '2', '0', ' ', 'm'}, Conn: &net.TCPConn{}}
+	tests := []struct {
+		name    string
+		version uint16
+		err     error
+		want    error
+	}{
+		{"tls 1.3", tls.VersionTLS13, nil, nil},
+		{"tls 1.2", tls.VersionTLS12, nil, nil},
+		{"tls 1.0", tls.VersionTLS10, nil, errTLSVersion},
+		{"quic alert", 0, tlsAlertProtocolVersion, errTLSVersion},
+		{"other quic alert", 0, tls.AlertError(40), tls.AlertError(40)},
+		{"remote alert", 0, &net.OpError{Op: "remote error", Err: tlsAlertProtocolVersion}, errTLSVersion},
+		{"sslv2 record", 0, tls.RecordHeaderError{RecordHeader: [5]byte{0x80, 0x2e, 0x01, 0x00, 0x02}}, errTLSVersion},
+		{"old record", 0, tls.RecordHeaderError{RecordHeader: [5]byte{0x16, 0x03, 0x01, 0x00, 0x10}}, errTLSVersion},
+		{"not tls", 0, notTLS, notTLS},
+		{"other", 0, other, other},
+	}
+	for _, tt := range tests {
+		if got := tlsVersionError(tt.version, tt.err); got != tt.want {
+			t.Errorf("%s: tlsVersionError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImplicitTLSVersion(t *testing.T) {
+	saved := config.tlsMinVersion
+	defer func() { config.tlsMinVersion = saved }()
+	cert := selfSignedCert(t)
+
+	tests := []struct {
+		name       string
+		min        uint16
+		serverMax  uint16
+		wantErr    error
+		wantSecure bool
+	}{
+		{"above the minimum", tls.VersionTLS12, tls.VersionTLS13, nil, true},
+		{"at the minimum", tls.VersionTLS12, tls.VersionTLS12, nil, true},
+		{"below the minimum", tls.VersionTLS12, tls.VersionTLS10, errTLSVersion, false},
+		{"old minimum", tls.VersionTLS10, tls.VersionTLS10, nil, true},
+	}
+	for _, tt := range tests {
+		config.tlsMinVersion = tt.min
+		client, server := net.Pipe()
+		go func() {
+			s := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS10, MaxVersion: tt.serverMax})
+			if s.Handshake() == nil {
+				io.Copy(io.Discard, s)
+			}
+			s.Close()
+		}()
+		c, err := implicitTLS(context.Background(), client, "mx.example.com", &domainOverride{})
+		if err != tt.wantErr {
+			t.Errorf("%s: implicitTLS error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if (c != nil) != tt.wantSecure {
+			t.Errorf("%s: implicitTLS conn = %v, want one %v", tt.name, c, tt.wantSecure)
+		}
+		client.Close()
+	}
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"mx.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
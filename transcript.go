@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+)
+
+// transcript records the smtp conversation line by line, "C: " for our commands and "S: " for
+// the responses of the server, up to smtp.transcript.maxsize bytes. it is taken at the text level
+// of the smtp client, so what goes over tls after STARTTLS is recorded as well
+type transcript struct {
+	buf       []byte
+	max       int
+	on        bool
+	truncated bool
+}
+
+// transcriptSide writes one side of the conversation to the transcript, starting each line with its prefix
+type transcriptSide struct {
+	t       *transcript
+	prefix  string
+	midLine bool
+}
+
+func (s *transcriptSide) Write(p []byte) (int, error) {
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !s.midLine {
+			s.t.add([]byte(s.prefix))
+		}
+		s.t.add(line)
+		s.midLine = line[len(line)-1] != '\n'
+	}
+	return len(p), nil
+}
+
+// flushingTee passes the commands on to the writer of the connection, flushing it right away,
+// the text conn flushes only the outer writer
+type flushingTee struct {
+	w    *bufio.Writer
+	side *transcriptSide
+}
+
+func (f *flushingTee) Write(p []byte) (int, error) {
+	f.side.Write(p)
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.w.Flush()
+	}
+	return n, err
+}
+
+func (t *transcript) add(b []byte) {
+	if !t.on || t.truncated {
+		return
+	}
+	if len(t.buf)+len(b) > t.max {
+		t.buf = append(t.buf, b[:t.max-len(t.buf)]...)
+		t.truncated = true
+		return
+	}
+	t.buf = append(t.buf, b...)
+}
+
+// greeting records the greeting of the server, read before the transcript was attached
+func (t *transcript) greeting(b []byte) {
+	(&transcriptSide{t: t, prefix: "S: "}).Write(b)
+}
+
+// reset empties the transcript, which records from now on only when on
+func (t *transcript) reset(on bool) {
+	t.buf = t.buf[:0]
+	t.on = on
+	t.truncated = false
+}
+
+func (t *transcript) String() string {
+	if t.truncated {
+		return string(t.buf) + "\n[truncated]"
+	}
+	return string(t.buf)
+}
+
+// attach tees both directions of the text conn into the transcript
+func (t *transcript) attach(text *textproto.Conn) {
+	text.Reader.R = bufio.NewReader(io.TeeReader(text.Reader.R, &transcriptSide{t: t, prefix: "S: "}))
+	text.Writer.W = bufio.NewWriter(&flushingTee{w: text.Writer.W, side: &transcriptSide{t: t, prefix: "C: "}})
+}
+
+// record starts the transcript of the conversation from now on, or stops it when the request
+// did not ask for one. a warm connection keeps its transcript from one email to the next
+func (c *mxClient) record(on bool) {
+	if c.transcript == nil {
+		if !on {
+			return
+		}
+		c.transcript = &transcript{max: config.SMTPTranscriptMaxSize}
+	}
+	c.transcript.reset(on)
+	c.follow()
+}
+
+// follow attaches the transcript to the text conn of the smtp client, which makes a new one on STARTTLS
+func (c *mxClient) follow() {
+	if c.transcript == nil || c.recorded == c.Text {
+		return
+	}
+	c.transcript.attach(c.Text)
+	c.recorded = c.Text
+}
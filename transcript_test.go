@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTranscriptSides(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int
+		writes []string
+		want   string
+	}{
+		{"lines", 1024, []string{"S|220 mx ready\r\n", "C|EHLO a.com\r\n", "S|250-mx\r\n250 SIZE\r\n"},
+			"S: 220 mx ready\r\nC: EHLO a.com\r\nS: 250-mx\r\nS: 250 SIZE\r\n"},
+		{"split line", 1024, []string{"S|220 mx", " ready\r\n", "C|QUIT\r\n"},
+			"S: 220 mx ready\r\nC: QUIT\r\n"},
+		{"truncated", 20, []string{"S|220 mx ready\r\n", "C|EHLO a.com\r\n"},
+			"S: 220 mx ready\r\nC: \n[truncated]"},
+		{"off", 0, []string{"S|220 mx ready\r\n"}, ""},
+	}
+	for _, tt := range tests {
+		tr := &transcript{max: tt.max}
+		tr.reset(tt.max > 0)
+		sides := map[string]*transcriptSide{"S": {t: tr, prefix: "S: "}, "C": {t: tr, prefix: "C: "}}
+		side := sides["S"]
+		for _, w := range tt.writes {
+			if s, rest, ok := strings.Cut(w, "|"); ok {
+				side, w = sides[s], rest
+			}
+			side.Write([]byte(w))
+		}
+		if got := tr.String(); got != tt.want {
+			t.Errorf("%s: transcript = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateEmailTranscript(t *testing.T) {
+	literal, overrides := config.EmailIPLiteral, config.DomainsOverrides
+	t.Cleanup(func() {
+		config.EmailIPLiteral, config.DomainsOverrides = literal, overrides
+	})
+	config.EmailIPLiteral = "probe"
+
+	tests := []struct {
+		name      string
+		asked     bool
+		rcpt      string
+		wantLines []string
+		wantEmpty bool
+	}{
+		{"rejected", true, "550 5.1.1 no such user", []string{"S: 220 mx.example.com ESMTP", "C: EHLO ", "C: MAIL FROM:", "C: RCPT TO:<transcript0@[127.0.0.1]>", "S: 550 5.1.1 no such user"}, false},
+		{"accepted", true, "250 2.1.5 ok", nil, true},
+		{"not asked", false, "550 5.1.1 no such user", nil, true},
+	}
+	for i, tt := range tests {
+		rcpt := tt.rcpt
+		mx := startFakeMX(t, &fakeMX{ehlo: []string{"PIPELINING"}, rcpt: func(string) string { return rcpt }})
+		config.DomainsOverrides = map[string]*domainOverride{"[127.0.0.1]": {Port: mx.port(), Timeout: 5}}
+
+		ctx := withRequestOptions(context.Background(), &requestOptions{transcript: tt.asked})
+		res := &emailResult{}
+		validateEmail(ctx, fmt.Sprintf("transcript%d@[127.0.0.1]", i), res)
+		if tt.wantEmpty != (res.Transcript == "") {
+			t.Errorf("%s: transcript = %q, want it empty %v", tt.name, res.Transcript, tt.wantEmpty)
+		}
+		for _, l := range tt.wantLines {
+			if !strings.Contains(res.Transcript, l) {
+				t.Errorf("%s: transcript %q lacks %q", tt.name, res.Transcript, l)
+			}
+		}
+	}
+}
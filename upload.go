@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// uploadMemory is how much of an upload is held in memory, the rest of it goes to temporary files
+const uploadMemory = 1 << 20
+
+// readRequestEmails reads the emails of the request, either the json array body
+// or the csv or text file of a multipart/form-data upload, up to request.maxbytes
+func readRequestEmails(r *http.Request) (incomingEmails, error) {
+	if config.RequestMaxBytes > 0 {
+		r.Body = http.MaxBytesReader(nil, r.Body, int64(config.RequestMaxBytes))
+	}
+
+	var iem incomingEmails
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		iem, err = readUploadedEmails(r)
+	} else {
+		iem, err = readEmails(r.Body)
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return nil, errPayloadTooLarge
+	}
+	return iem, err
+}
+
+// readUploadedEmails takes the emails out of the file of the upload, the first file field in name order,
+// one row at a time. any csv field, or word of a text line, with an @ in it counts as an email, so headers
+// and extra columns like names are skipped. the request.maxemails limit applies as usual
+func readUploadedEmails(r *http.Request) (incomingEmails, error) {
+	if err := r.ParseMultipartForm(uploadMemory); err != nil {
+		return nil, err
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var fields []string
+	for field, files := range r.MultipartForm.File {
+		if len(files) > 0 {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no file found in the upload")
+	}
+	sort.Strings(fields)
+	f, err := r.MultipartForm.File[fields[0]][0].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+
+	var iem incomingEmails
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return iem, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range record {
+			for _, e := range strings.FieldsFunc(field, func(c rune) bool { return c == ';' || c == '\t' || c == ' ' }) {
+				if !strings.Contains(e, "@") {
+					continue
+				}
+				if config.RequestMaxEmails > 0 && len(iem) >= config.RequestMaxEmails {
+					return nil, errTooManyEmails
+				}
+				iem = append(iem, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func uploadRequest(t *testing.T, files map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("note", "not a file")
+	for field, content := range files {
+		fw, err := mw.CreateFormFile(field, field+".csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestReadUploadedEmails(t *testing.T) {
+	defer func(maxBytes, maxEmails int) {
+		config.RequestMaxBytes, config.RequestMaxEmails = maxBytes, maxEmails
+	}(config.RequestMaxBytes, config.RequestMaxEmails)
+	config.RequestMaxBytes, config.RequestMaxEmails = 1024, 3
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  incomingEmails
+		err   error
+	}{
+		{"csv", map[string]string{"file": "email,name\na@example.com,A\nb@example.com;c@example.com,B\n"}, incomingEmails{"a@example.com", "b@example.com", "c@example.com"}, nil},
+		{"first field in name order", map[string]string{"b": "b@example.com\n", "a": "a@example.com\n"}, incomingEmails{"a@example.com"}, nil},
+		{"too many emails", map[string]string{"file": "a@example.com b@example.com c@example.com d@example.com\n"}, nil, errTooManyEmails},
+		{"too large", map[string]string{"file": strings.Repeat("a@example.com\n", 100)}, nil, errPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := readRequestEmails(uploadRequest(t, tt.files))
+		if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readRequestEmails = %v, %v, want %v, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+
+	if _, err := readRequestEmails(uploadRequest(t, nil)); err == nil {
+		t.Error("an upload without any file was accepted")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// warmCmdTimeout bounds the commands sent on a parked connection, nobody's context watches them
+const warmCmdTimeout = 5 * time.Second
+
+// warmConns keeps the connections to the popular mx hosts open after the RCPT check, so the
+// next validation for the same host skips the connect, the EHLO and the STARTTLS.
+// a parked connection does not count towards smtp.maxconnections until it is taken again
+type warmConns struct {
+	sync.Mutex
+	hosts *domainsList
+	size  int
+	idle  time.Duration
+	conns map[string][]*warmConn
+}
+
+type warmConn struct {
+	*mxClient
+	parkedAt time.Time
+}
+
+// warmKey tells the connections apart by everything the greeting depends on
+func warmKey(host, domainName string, ov *domainOverride) string {
+	return mxAddr(host, ov) + " " + ov.helo(domainName) + " " + ov.TLS
+}
+
+// take returns a parked connection for the key, watching the given context from now on.
+// the server may have dropped it meanwhile, so each one is checked with NOOP before it's handed out
+func (w *warmConns) take(ctx context.Context, key string) *mxClient {
+	if w == nil {
+		return nil
+	}
+	for {
+		w.Lock()
+		list := w.conns[key]
+		if len(list) == 0 {
+			w.Unlock()
+			return nil
+		}
+		wc := list[len(list)-1]
+		w.conns[key] = list[:len(list)-1]
+		w.Unlock()
+
+		if time.Since(wc.parkedAt) > w.idle {
+			w.discard(wc.mxClient)
+			continue
+		}
+		wc.conn.SetDeadline(time.Now().Add(warmCmdTimeout))
+		if err := wc.Noop(); err != nil {
+			wc.Close()
+			continue
+		}
+		wc.conn.SetDeadline(time.Time{})
+
+		if err := smtpConns.acquire(ctx, connPrimary); err != nil {
+			w.park(key, wc.mxClient)
+			return nil
+		}
+		c := wc.mxClient
+		c.stopWatch = context.AfterFunc(ctx, func() {
+			c.conn.Close()
+		})
+		return c
+	}
+}
+
+// put parks the connection once its validation is done, with RSET to start over. the tls state
+// stays with the connection, so the next recipient goes on the same secured session without
+// another STARTTLS. it is closed instead when the host is not a warm one, the pool is full
+// or the conversation can't go on
+func (w *warmConns) put(c *mxClient, host, key string) {
+	if w == nil || c.aborted || !w.hosts.matches(host) {
+		c.close()
+		return
+	}
+	defer smtpConns.release()
+	if !c.stopWatch() {
+		// the context is done and the watcher already closed the connection
+		return
+	}
+	c.conn.SetDeadline(time.Now().Add(warmCmdTimeout))
+	if err := c.Reset(); err != nil {
+		c.Close()
+		return
+	}
+	c.conn.SetDeadline(time.Time{})
+	w.park(key, c)
+}
+
+func (w *warmConns) park(key string, c *mxClient) {
+	w.Lock()
+	if len(w.conns[key]) >= w.size {
+		w.Unlock()
+		w.discard(c)
+		return
+	}
+	w.conns[key] = append(w.conns[key], &warmConn{mxClient: c, parkedAt: time.Now()})
+	w.Unlock()
+}
+
+// discard ends the conversation of a parked connection, closing it when QUIT fails
+func (w *warmConns) discard(c *mxClient) {
+	c.conn.SetDeadline(time.Now().Add(warmCmdTimeout))
+	if err := c.Quit(); err != nil {
+		c.Close()
+	}
+}
+
+// gcHandler closes the connections idle for too long, the servers drop them sooner or later anyway
+func (w *warmConns) gcHandler() {
+	ticker := time.NewTicker(w.idle / 2)
+	for _ = range ticker.C {
+		var expired []*warmConn
+		w.Lock()
+		for k, list := range w.conns {
+			fresh := list[:0]
+			for _, wc := range list {
+				if time.Since(wc.parkedAt) > w.idle {
+					expired = append(expired, wc)
+				} else {
+					fresh = append(fresh, wc)
+				}
+			}
+			w.conns[k] = fresh
+		}
+		w.Unlock()
+
+		for _, wc := range expired {
+			w.discard(wc.mxClient)
+		}
+	}
+}
+
+func newWarmConns(hosts string, size int, idle time.Duration) *warmConns {
+	w := &warmConns{
+		hosts: newDomainsList(""),
+		size:  size,
+		idle:  idle,
+		conns: make(map[string][]*warmConn),
+	}
+	w.hosts.addCSV(hosts)
+	go w.gcHandler()
+	return w
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmConnsReuse(t *testing.T) {
+	literal, overrides, pool := config.EmailIPLiteral, config.DomainsOverrides, warmPool
+	t.Cleanup(func() {
+		config.EmailIPLiteral, config.DomainsOverrides, warmPool = literal, overrides, pool
+	})
+	config.EmailIPLiteral = "probe"
+
+	tests := []struct {
+		name      string
+		hosts     string
+		size      int
+		idle      time.Duration
+		wantConns int32
+	}{
+		{"warm host", "127.0.0.1", 2, time.Minute, 1},
+		{"other host", "mx.example.com", 2, time.Minute, 3},
+		{"idle too long", "127.0.0.1", 2, time.Millisecond, 3},
+		{"no pool", "", 0, 0, 3},
+	}
+	for i, tt := range tests {
+		mx := startFakeMX(t, &fakeMX{ehlo: []string{"PIPELINING"}})
+		config.DomainsOverrides = map[string]*domainOverride{"[127.0.0.1]": {Port: mx.port(), Timeout: 5}}
+		// built by hand, the gc of newWarmConns would tick until the end of all the tests
+		warmPool = nil
+		if tt.size > 0 {
+			warmPool = &warmConns{hosts: newDomainsList(""), size: tt.size, idle: tt.idle, conns: make(map[string][]*warmConn)}
+			warmPool.hosts.addCSV(tt.hosts)
+		}
+
+		for j := 0; j < 3; j++ {
+			if tt.idle == time.Millisecond {
+				time.Sleep(5 * time.Millisecond)
+			}
+			email := fmt.Sprintf("warm%d-%d@[127.0.0.1]", i, j)
+			if got := validateEmail(context.Background(), email, &emailResult{}); got != "OK" {
+				t.Errorf("%s: validateEmail(%s) = %q, want OK", tt.name, email, got)
+			}
+		}
+		if got := atomic.LoadInt32(&mx.conns); got != tt.wantConns {
+			t.Errorf("%s: %d connections for 3 emails, want %d", tt.name, got, tt.wantConns)
+		}
+	}
+}
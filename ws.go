@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wsResult is the message pushed back for each email received over the websocket
+type wsResult struct {
+	Email     string       `json:"email"`
+	Message   string       `json:"message"`
+	ErrorCode string       `json:"errorCode,omitempty"`
+	Result    *emailResult `json:"result,omitempty"`
+}
+
+// wsTokenProtocol prefixes the password sent as a subprotocol, browsers can't set the
+// Authorization header of a websocket, new WebSocket(url, ["token.PASSWORD"])
+const wsTokenProtocol = "token."
+
+// wsHandshake checks the origin and the password before upgrading the connection. a browser
+// always sends its origin, which has to be ours or one of ws.origins, otherwise any page could
+// use the visitor's access to the server. the password comes with the Authorization header like
+// for the other endpoints, or from a browser with ?token= or the token subprotocol
+func wsHandshake(cfg *websocket.Config, r *http.Request) error {
+	if origin := r.Header.Get("Origin"); len(origin) > 0 && !wsOriginAllowed(origin, r.Host) {
+		return errors.New("origin not allowed")
+	}
+	if len(config.Password) == 0 {
+		return nil
+	}
+	if r.Header.Get("Authorization") == config.Password || r.URL.Query().Get("token") == config.Password {
+		return nil
+	}
+	for _, p := range cfg.Protocol {
+		if p == wsTokenProtocol+config.Password {
+			// the subprotocol is echoed back, the browser closes the connection otherwise
+			cfg.Protocol = []string{p}
+			return nil
+		}
+	}
+	return errors.New("invalid password")
+}
+
+// wsOriginAllowed reports whether the origin is one of ws.origins, or the host of the server
+// itself when ws.origins is empty
+func wsOriginAllowed(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || len(u.Host) == 0 {
+		return false
+	}
+	if len(config.WSOrigins) == 0 {
+		return strings.EqualFold(u.Host, host)
+	}
+	for _, o := range strings.Split(config.WSOrigins, ",") {
+		if strings.EqualFold(strings.TrimRight(strings.TrimSpace(o), "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsHandler validates the emails sent over the websocket, one per message either as is or
+// as a json string, pushing back each result as soon as it is ready.
+// each connection validates at most ws.ratelimit emails per second
+func wsHandler(ws *websocket.Conn) {
+	defer ws.Close()
+	if config.Verbose {
+		fmt.Println("Incoming websocket from:", ws.Request().RemoteAddr)
+	}
+
+	ctx, cancel := context.WithCancel(withRequestOptions(ws.Request().Context(), &requestOptions{clientIP: clientIP(ws.Request()), locale: requestedLocale(ws.Request()), noCache: noCacheRequested(ws.Request())}))
+	defer cancel()
+
+	var tick <-chan time.Time
+	if config.WSRateLimit > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(config.WSRateLimit))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		email := strings.TrimSpace(msg)
+		var quoted string
+		if json.Unmarshal([]byte(email), &quoted) == nil {
+			email = quoted
+		}
+
+		if tick != nil {
+			<-tick
+		}
+
+		if err := websocket.JSON.Send(ws, wsValidate(ctx, email)); err != nil {
+			return
+		}
+	}
+}
+
+// wsValidate validates the email of one message, the bad messages and the busy server
+// are answered with the error codes of the http endpoints
+func wsValidate(ctx context.Context, email string) *wsResult {
+	if len(email) == 0 {
+		return &wsResult{Email: email, Message: "Empty payload, expecting an email", ErrorCode: "EMPTY_PAYLOAD"}
+	}
+	if memGuard.exceeded() {
+		return &wsResult{Email: email, Message: "Server is low on memory, try again later", ErrorCode: "SERVER_BUSY"}
+	}
+
+	emails := cleanEmails(incomingEmails{email})
+	o, ok := processEmails(ctx, emails)
+	defer o.release()
+	if !ok {
+		return &wsResult{Email: email, Message: "Server is busy, try again later", ErrorCode: "SERVER_BUSY"}
+	}
+	return &wsResult{Email: emails[0], Message: o.Emails[emails[0]], Result: o.Results[emails[0]]}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/net/websocket"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWSOriginAllowed(t *testing.T) {
+	tests := []struct {
+		origins string
+		origin  string
+		host    string
+		want    bool
+	}{
+		{"", "http://evs.example.com:8000", "evs.example.com:8000", true},
+		{"", "http://evil.example.net", "evs.example.com:8000", false},
+		{"", "null", "evs.example.com:8000", false},
+		{"https://app.example.com", "https://app.example.com", "evs.example.com", true},
+		{"https://app.example.com/", "https://app.example.com", "evs.example.com", true},
+		{"https://app.example.com, https://admin.example.com", "https://admin.example.com", "evs.example.com", true},
+		{"https://app.example.com", "http://app.example.com", "evs.example.com", false},
+		{"https://app.example.com", "http://evs.example.com", "evs.example.com", false},
+	}
+	defer func(origins string) { config.WSOrigins = origins }(config.WSOrigins)
+	for _, tt := range tests {
+		config.WSOrigins = tt.origins
+		if got := wsOriginAllowed(tt.origin, tt.host); got != tt.want {
+			t.Errorf("wsOriginAllowed(%q, %q) with %q = %v, want %v", tt.origin, tt.host, tt.origins, got, tt.want)
+		}
+	}
+}
+
+func TestWSHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		password  string
+		target    string
+		origin    string
+		auth      string
+		protocols []string
+		ok        bool
+		protocol  []string
+	}{
+		{"open, no origin", "", "/ws", "", "", nil, true, nil},
+		{"open, same origin", "", "/ws", "http://example.com", "", nil, true, nil},
+		{"open, cross origin", "", "/ws", "http://evil.example.net", "", nil, false, nil},
+		{"header", "pw", "/ws", "", "pw", nil, true, nil},
+		{"no password", "pw", "/ws", "", "", nil, false, nil},
+		{"query token", "pw", "/ws?token=pw", "http://example.com", "", nil, true, nil},
+		{"bad query token", "pw", "/ws?token=x", "http://example.com", "", nil, false, nil},
+		{"subprotocol", "pw", "/ws", "http://example.com", "", []string{"chat", "token.pw"}, true, []string{"token.pw"}},
+		{"bad subprotocol", "pw", "/ws", "http://example.com", "", []string{"token.x"}, false, nil},
+		{"token, cross origin", "pw", "/ws?token=pw", "http://evil.example.net", "", nil, false, nil},
+	}
+	defer func(password string) { config.Password = password }(config.Password)
+	for _, tt := range tests {
+		config.Password = tt.password
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if len(tt.origin) > 0 {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if len(tt.auth) > 0 {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		cfg := &websocket.Config{Protocol: tt.protocols}
+		err := wsHandshake(cfg, r)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: wsHandshake error %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.protocol != nil && (len(cfg.Protocol) != 1 || cfg.Protocol[0] != tt.protocol[0]) {
+			t.Errorf("%s: protocol %v, want %v", tt.name, cfg.Protocol, tt.protocol)
+		}
+	}
+}
+
+func TestWSValidateRejects(t *testing.T) {
+	tests := []struct {
+		email    string
+		lowOnMem bool
+		code     string
+	}{
+		{"", false, "EMPTY_PAYLOAD"},
+		{"a@example.com", true, "SERVER_BUSY"},
+	}
+	defer func() { memGuard = nil }()
+	for _, tt := range tests {
+		memGuard = nil
+		if tt.lowOnMem {
+			memGuard = &memoryGuard{paused: 1}
+		}
+		if got := wsValidate(context.Background(), tt.email); got.ErrorCode != tt.code {
+			t.Errorf("wsValidate(%q) low on memory %v = %q, want %q", tt.email, tt.lowOnMem, got.ErrorCode, tt.code)
+		}
+	}
+}